@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"hw/pipeline"
+	"hw/transport"
+)
+
+// record is one line of input with its hashes
+type record struct {
+	Record     string `json:"record"`
+	SingleHash string `json:"single_hash"`
+	MultiHash  string `json:"multi_hash"`
+}
+
+// remoteRequest is a value for a MultiHash worker, Salt is the fingerprint of DataSignerSalt of the client
+type remoteRequest struct {
+	Salt string `json:"salt"`
+	Data string `json:"data"`
+}
+
+// saltFingerprint lets a worker check the salt of a client without sending the salt itself
+func saltFingerprint(salt string) string {
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(salt))), 16)
+}
+
+// remoteMultiHash is OrderedMultiHash for the clients with -remote, a worker hashes with its own
+// DataSignerSalt so it refuses the values of a client with another one
+func remoteMultiHash(hasher *Hasher, workers int) pipeline.Stage[remoteRequest, string] {
+	salt := saltFingerprint(DataSignerSalt)
+	return pipeline.OrderedMap(workers, func(ctx context.Context, r remoteRequest) (string, error) {
+		if r.Salt != salt {
+			return "", fmt.Errorf("salt of the client does not match -salt of the worker")
+		}
+		return hasher.MultiHash(r.Data), nil
+	})
+}
+
+// run is the signer command: it reads records, one per line, from the files or stdin, and prints
+// SingleHash and MultiHash of every record in input order, or the CombineResults of all of them.
+// With -serve it is a MultiHash worker for other signers started with -remote
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("signer", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: signer [flags] [file ...]\nreads stdin when there are no files or the file is -")
+		flags.PrintDefaults()
+	}
+	salt := flags.String("salt", DataSignerSalt, "salt of the DataSigner functions")
+	workers := flags.Int("workers", MaxInputDataLen, "how many records are hashed at the same time")
+	format := flags.String("format", "text", "output: text (record and hash), json (one object per line) or combined (CombineResults)")
+	serve := flags.String("serve", "", "run as a MultiHash worker on tcp:host:port or unix:/path")
+	remote := flags.String("remote", "", "comma separated addresses of MultiHash workers started with -serve")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *workers < 1 {
+		return fmt.Errorf("workers must be positive")
+	}
+	if *format != "text" && *format != "json" && *format != "combined" {
+		return fmt.Errorf("unknown format %s", *format)
+	}
+	DataSignerSalt = *salt
+	hasher := NewHasher(newSigners(newResources()))
+
+	if *serve != "" {
+		return serveMultiHash(*serve, remoteMultiHash(hasher, *workers), stderr)
+	}
+
+	multi := func(ctx context.Context, data string) (string, error) {
+		return hasher.MultiHash(data), nil
+	}
+	if *remote != "" {
+		dial, err := transport.Dial(strings.Split(*remote, ",")...)
+		if err != nil {
+			return err
+		}
+		client := transport.NewClient[remoteRequest, string](dial, *workers)
+		defer client.Close()
+		salt := saltFingerprint(DataSignerSalt)
+		multi = func(ctx context.Context, data string) (string, error) {
+			return client.Call(ctx, remoteRequest{Salt: salt, Data: data})
+		}
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	p := pipeline.Then(pipeline.Generate(func(ctx context.Context, out chan<- record) error {
+		for _, name := range files {
+			if err := readRecords(ctx, name, stdin, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	}), pipeline.OrderedMap(*workers, func(ctx context.Context, r record) (record, error) {
+		r.SingleHash = hasher.SingleHash(r.Record)
+		return r, nil
+	}))
+	p = pipeline.Then(p, pipeline.OrderedMap(*workers, func(ctx context.Context, r record) (record, error) {
+		hash, err := multi(ctx, r.SingleHash)
+		r.MultiHash = hash
+		return r, err
+	}))
+
+	if *format == "combined" {
+		hashes := pipeline.Then(p, func(ctx context.Context, in <-chan record, out chan<- string) error {
+			for r := range in {
+				if err := pipeline.Send(ctx, out, r.MultiHash); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return pipeline.Then(hashes, CombineResultsStage).Run(context.Background(), func(result string) error {
+			_, err := fmt.Fprintln(stdout, result)
+			return err
+		})
+	}
+
+	// records are printed one by one as soon as they are ready
+	encoder := json.NewEncoder(stdout)
+	return p.Run(context.Background(), func(r record) error {
+		if *format == "json" {
+			return encoder.Encode(r)
+		}
+		_, err := fmt.Fprintf(stdout, "%s\t%s\n", r.Record, r.MultiHash)
+		return err
+	})
+}
+
+// serveMultiHash runs the remoteMultiHash stage for the clients with -remote until the process is interrupted
+func serveMultiHash(addr string, stage pipeline.Stage[remoteRequest, string], stderr io.Writer) error {
+	ln, err := transport.Listen(addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	fmt.Fprintln(stderr, "serving MultiHash on", addr)
+	return transport.Serve(ctx, ln, stage)
+}
+
+// readRecords sends the non-empty lines of a file, - is stdin
+func readRecords(ctx context.Context, name string, stdin io.Reader, out chan<- record) error {
+	input := stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		if err := pipeline.Send(ctx, out, record{Record: scanner.Text()}); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"hw/transport"
+)
+
+// fastSigners replaces the DataSigner functions with the same hashes without time.Sleep
+func fastSigners(t *testing.T) {
+	md5Signer, crc32Signer, salt := DataSignerMd5, DataSignerCrc32, DataSignerSalt
+	t.Cleanup(func() {
+		DataSignerMd5, DataSignerCrc32, DataSignerSalt = md5Signer, crc32Signer, salt
+	})
+
+	DataSignerMd5 = func(data string) string {
+		return fmt.Sprintf("%x", md5.Sum([]byte(data+DataSignerSalt)))
+	}
+	DataSignerCrc32 = func(data string) string {
+		return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(data+DataSignerSalt))), 10)
+	}
+}
+
+func TestCLI(t *testing.T) {
+	fastSigners(t)
+
+	// MultiHash of 0 and 1 from readme.md
+	hash0 := "29568666068035183841425683795340791879727309630931025356555"
+	hash1 := "4958044192186797981418233587017209679042592862002427381542"
+
+	file := filepath.Join(t.TempDir(), "records.txt")
+	if err := os.WriteFile(file, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Args     []string
+		Stdin    string
+		Expected string
+	}{
+		{nil, "0\n\n1\n", "0\t" + hash0 + "\n1\t" + hash1 + "\n"},
+		{[]string{"-workers", "1", "-", file}, "0", "0\t" + hash0 + "\n1\t" + hash1 + "\n"},
+		{[]string{"-format", "combined", file, "-"}, "0\n", hash0 + "_" + hash1 + "\n"},
+	}
+	for _, item := range cases {
+		var stdout, stderr bytes.Buffer
+		if err := run(item.Args, strings.NewReader(item.Stdin), &stdout, &stderr); err != nil {
+			t.Errorf("[%v] unexpected error: %v", item.Args, err)
+			continue
+		}
+		if stdout.String() != item.Expected {
+			t.Errorf("[%v] results not match\nGot: %q\nExpected: %q", item.Args, stdout.String(), item.Expected)
+		}
+	}
+
+	var stdout bytes.Buffer
+	if err := run([]string{"-format", "json", "-salt", "pepper"}, strings.NewReader("0\n"), &stdout, &bytes.Buffer{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var result record
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("bad json %q: %v", stdout.String(), err)
+	}
+	if result.Record != "0" || result.MultiHash == "" || result.MultiHash == hash0 {
+		t.Errorf("expected a salted hash of 0, got %+v", result)
+	}
+}
+
+func TestCLIRemote(t *testing.T) {
+	fastSigners(t)
+
+	ln, err := transport.Listen("unix:" + filepath.Join(t.TempDir(), "multihash.sock"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- transport.Serve(context.Background(), ln, remoteMultiHash(NewHasher(newSigners(newResources())), 4))
+	}()
+	defer func() {
+		ln.Close()
+		if err := <-done; err != nil {
+			t.Errorf("unexpected serve error: %v", err)
+		}
+	}()
+
+	var local, remote bytes.Buffer
+	input := "0\n1\n2\n3\n5\n8\n"
+	if err := run(nil, strings.NewReader(input), &local, &bytes.Buffer{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	args := []string{"-workers", "3", "-remote", "unix:" + ln.Addr().String()}
+	if err := run(args, strings.NewReader(input), &remote, &bytes.Buffer{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if remote.String() != local.String() {
+		t.Errorf("results not match\nGot: %q\nExpected: %q", remote.String(), local.String())
+	}
+
+	// the worker hashes with its own salt, so it refuses a client with another one
+	var remoteErr *transport.RemoteError
+	args = []string{"-salt", "other", "-remote", "unix:" + ln.Addr().String()}
+	if err := run(args, strings.NewReader(input), &bytes.Buffer{}, &bytes.Buffer{}); !errors.As(err, &remoteErr) {
+		t.Errorf("expected a remote error for another salt, got %v", err)
+	}
+
+	if err := run([]string{"-remote", "udp:127.0.0.1:1"}, strings.NewReader(input), &bytes.Buffer{}, &bytes.Buffer{}); err == nil {
+		t.Errorf("expected an error for a bad address")
+	}
+}
+
+func TestCLIErrors(t *testing.T) {
+	fastSigners(t)
+
+	for _, args := range [][]string{
+		{"-format", "xml"},
+		{"-workers", "0"},
+		{"-unknown"},
+		{filepath.Join(t.TempDir(), "missing.txt")},
+	} {
+		if err := run(args, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}); err == nil {
+			t.Errorf("[%v] expected an error", args)
+		}
+	}
+}
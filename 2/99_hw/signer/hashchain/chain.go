@@ -0,0 +1,228 @@
+package hashchain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// inputName is the variable of a chain that holds the data being hashed
+const inputName = "x"
+
+// Chain is a parsed hash expression:
+//
+//	expr = part { ("+" | "~") part }
+//	part = "x" | number | "string" | name "(" expr ")"
+//
+// + concatenates, ~ concatenates with "~" between. The parts of a concatenation are
+// evaluated in parallel, so crc32(x)~crc32(md5(x)) runs both crc32 at the same time
+type Chain struct {
+	expr string
+	root node
+}
+
+type node interface {
+	eval(data string) string
+}
+
+type input struct{}
+
+func (input) eval(data string) string {
+	return data
+}
+
+type literal string
+
+func (l literal) eval(string) string {
+	return string(l)
+}
+
+type call struct {
+	algo *algorithm
+	arg  node
+}
+
+func (c call) eval(data string) string {
+	return c.algo.sum(c.arg.eval(data))
+}
+
+type concat struct {
+	parts []node
+	seps  []string
+}
+
+func (c concat) eval(data string) string {
+	results := make([]string, len(c.parts))
+	wg := &sync.WaitGroup{}
+	for i, part := range c.parts {
+		wg.Add(1)
+		go func(i int, part node) {
+			defer wg.Done()
+			results[i] = part.eval(data)
+		}(i, part)
+	}
+	wg.Wait()
+
+	var sb strings.Builder
+	for i, result := range results {
+		if i > 0 {
+			sb.WriteString(c.seps[i-1])
+		}
+		sb.WriteString(result)
+	}
+	return sb.String()
+}
+
+// Sum evaluates the chain with x = data
+func (c *Chain) Sum(data string) string {
+	return c.root.eval(data)
+}
+
+func (c *Chain) String() string {
+	return c.expr
+}
+
+// Parse compiles expr, every algorithm in it must be registered already
+func (r *Registry) Parse(expr string) (*Chain, error) {
+	p := &parser{registry: r, src: expr}
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return &Chain{expr: expr, root: root}, nil
+}
+
+// MustParse is Parse that panics on error, for expressions written in the code like the SingleHash formula
+func (r *Registry) MustParse(expr string) *Chain {
+	chain, err := r.Parse(expr)
+	if err != nil {
+		panic(err)
+	}
+	return chain
+}
+
+type parser struct {
+	registry *Registry
+	src      string
+	pos      int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("chain %q at %d: %s", p.src, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) expr() (node, error) {
+	first, err := p.part()
+	if err != nil {
+		return nil, err
+	}
+
+	c := concat{parts: []node{first}}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.src) || (p.src[p.pos] != '+' && p.src[p.pos] != '~') {
+			break
+		}
+		sep := ""
+		if p.src[p.pos] == '~' {
+			sep = "~"
+		}
+		p.pos++
+
+		part, err := p.part()
+		if err != nil {
+			return nil, err
+		}
+		c.parts = append(c.parts, part)
+		c.seps = append(c.seps, sep)
+	}
+
+	if len(c.parts) == 1 {
+		return first, nil
+	}
+	return c, nil
+}
+
+func isLetter(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch == '_'
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func (p *parser) part() (node, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("unexpected end")
+	}
+
+	start := p.pos
+	switch ch := p.src[p.pos]; {
+	case ch == '"':
+		end := p.pos + 1
+		for end < len(p.src) && p.src[end] != '"' {
+			if p.src[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.src) {
+			return nil, p.errorf("unclosed string")
+		}
+		value, err := strconv.Unquote(p.src[start : end+1])
+		if err != nil {
+			return nil, p.errorf("bad string: %v", err)
+		}
+		p.pos = end + 1
+		return literal(value), nil
+
+	case isDigit(ch):
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.pos++
+		}
+		return literal(p.src[start:p.pos]), nil
+
+	case isLetter(ch):
+		for p.pos < len(p.src) && (isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		p.skipSpaces()
+		if p.pos >= len(p.src) || p.src[p.pos] != '(' {
+			if name == inputName {
+				return input{}, nil
+			}
+			return nil, p.errorf("expected ( after %s", name)
+		}
+
+		algo, ok := p.registry.lookup(name)
+		if !ok {
+			return nil, p.errorf("unknown algorithm %s", name)
+		}
+		p.pos++
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if p.pos >= len(p.src) || p.src[p.pos] != ')' {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return call{algo: algo, arg: arg}, nil
+	}
+
+	return nil, p.errorf("unexpected %q", p.src[p.pos])
+}
@@ -0,0 +1,98 @@
+package hashchain
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testRegistry() *Registry {
+	r := NewRegistry()
+	r.MustRegister(
+		Algorithm{Name: "upper", Sum: strings.ToUpper},
+		Algorithm{Name: "rev", Sum: func(data string) string {
+			runes := []rune(data)
+			for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+				runes[i], runes[j] = runes[j], runes[i]
+			}
+			return string(runes)
+		}},
+	)
+	r.MustRegister(Standard()...)
+	return r
+}
+
+func TestChain(t *testing.T) {
+	r := testRegistry()
+	cases := []struct {
+		Expr     string
+		Data     string
+		Expected string
+	}{
+		{"x", "abc", "abc"},
+		{"upper(x)", "abc", "ABC"},
+		{"upper(x)~rev(x)", "abc", "ABC~cba"},
+		{"rev(upper(x))", "abc", "CBA"},
+		{"rev(0+x)+rev(1+x)", "ab", "ba0ba1"},
+		{`upper("a-" + x) ~ "end"`, "b", "A-B~end"},
+		{"sha256(x)", "abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"crc32c(x)", "abc", "910901175"},
+	}
+	for _, item := range cases {
+		chain, err := r.Parse(item.Expr)
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %v", item.Expr, err)
+			continue
+		}
+		if got := chain.Sum(item.Data); got != item.Expected {
+			t.Errorf("[%s] expected %q, got %q", item.Expr, item.Expected, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	r := testRegistry()
+	for _, expr := range []string{
+		"",
+		"md5(x)",
+		"upper(x",
+		"upper",
+		"upper(x)~",
+		`upper("x)`,
+		"x)",
+		"x*2",
+	} {
+		if _, err := r.Parse(expr); err == nil {
+			t.Errorf("[%s] expected an error", expr)
+		}
+	}
+
+	if err := r.Register(Algorithm{Name: "upper", Sum: strings.ToLower}); err == nil {
+		t.Errorf("expected an error for a duplicate name")
+	}
+	if err := r.Register(Algorithm{Name: "x", Sum: strings.ToLower}); err == nil {
+		t.Errorf("expected an error for x as a name")
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	var running, overheat int32
+	r := NewRegistry()
+	r.MustRegister(Algorithm{Name: "hot", MaxConcurrent: 1, Sum: func(data string) string {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.StoreInt32(&overheat, 1)
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return data
+	}})
+
+	chain := r.MustParse("hot(x)+hot(x)+hot(x)+hot(x)")
+	if got := chain.Sum("a"); got != "aaaa" {
+		t.Errorf("expected aaaa, got %q", got)
+	}
+	if atomic.LoadInt32(&overheat) != 0 {
+		t.Errorf("hot was called in parallel")
+	}
+}
@@ -0,0 +1,103 @@
+// Package hashchain keeps named hash algorithms and evaluates chains of them,
+// like crc32(x)~crc32(md5(x)) of SingleHash
+package hashchain
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// Algorithm is one hash function. MaxConcurrent limits how many calls may run at the same time,
+// 1 is for functions like DataSignerMd5 that overheat when called in parallel, 0 is no limit
+type Algorithm struct {
+	Name          string
+	Sum           func(data string) string
+	MaxConcurrent int
+}
+
+type algorithm struct {
+	Algorithm
+	slots chan struct{}
+}
+
+func (a *algorithm) sum(data string) string {
+	if a.slots != nil {
+		a.slots <- struct{}{}
+		defer func() { <-a.slots }()
+	}
+	return a.Sum(data)
+}
+
+var nameRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Registry is a set of algorithms by name, it is safe for concurrent use
+type Registry struct {
+	mu         sync.RWMutex
+	algorithms map[string]*algorithm
+}
+
+func NewRegistry() *Registry {
+	return &Registry{algorithms: map[string]*algorithm{}}
+}
+
+// Register adds an algorithm, the name must be lowercase letters, digits and _ and not taken yet.
+// x is not allowed because it is the input of a chain
+func (r *Registry) Register(a Algorithm) error {
+	if !nameRe.MatchString(a.Name) || a.Name == inputName {
+		return fmt.Errorf("bad algorithm name %q", a.Name)
+	}
+	if a.Sum == nil {
+		return fmt.Errorf("algorithm %s has no Sum", a.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.algorithms[a.Name]; ok {
+		return fmt.Errorf("algorithm %s is already registered", a.Name)
+	}
+	algo := &algorithm{Algorithm: a}
+	if a.MaxConcurrent > 0 {
+		algo.slots = make(chan struct{}, a.MaxConcurrent)
+	}
+	r.algorithms[a.Name] = algo
+	return nil
+}
+
+// MustRegister is Register that panics on error, for registries built from algorithms known at compile time
+func (r *Registry) MustRegister(algorithms ...Algorithm) {
+	for _, a := range algorithms {
+		if err := r.Register(a); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (r *Registry) lookup(name string) (*algorithm, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	algo, ok := r.algorithms[name]
+	return algo, ok
+}
+
+// Sum calls one algorithm, respecting its MaxConcurrent
+func (r *Registry) Sum(name, data string) (string, error) {
+	algo, ok := r.lookup(name)
+	if !ok {
+		return "", fmt.Errorf("unknown algorithm %s", name)
+	}
+	return algo.sum(data), nil
+}
+
+// Names returns the names of all algorithms in alphabetical order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.algorithms))
+	for name := range r.algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,30 @@
+package hashchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"hash/fnv"
+	"strconv"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Standard are algorithms from the standard library without limits: sha256 in hex,
+// crc32c and fnv64a in decimal like DataSignerCrc32. Others, xxhash for example, can be registered the same way
+func Standard() []Algorithm {
+	return []Algorithm{
+		{Name: "sha256", Sum: func(data string) string {
+			sum := sha256.Sum256([]byte(data))
+			return hex.EncodeToString(sum[:])
+		}},
+		{Name: "crc32c", Sum: func(data string) string {
+			return strconv.FormatUint(uint64(crc32.Checksum([]byte(data), castagnoli)), 10)
+		}},
+		{Name: "fnv64a", Sum: func(data string) string {
+			h := fnv.New64a()
+			h.Write([]byte(data))
+			return strconv.FormatUint(h.Sum64(), 10)
+		}},
+	}
+}
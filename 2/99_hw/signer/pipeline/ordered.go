@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+)
+
+// OrderedMap is a stage that calls fn for up to workers values at the same time and sends
+// the results in the order of input. A value finished early waits in the reorder buffer,
+// and a new value is not taken from the input until the oldest one is sent, so at most
+// workers values are in flight or buffered. The first error in input order stops the stage
+func OrderedMap[In, Out any](workers int, fn func(ctx context.Context, v In) (Out, error)) Stage[In, Out] {
+	if workers < 1 {
+		workers = 1
+	}
+
+	type result struct {
+		seq int
+		v   Out
+		err error
+	}
+
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		slots := make(chan struct{}, workers)
+		results := make(chan result, workers)
+
+		go func() {
+			wg := &sync.WaitGroup{}
+			defer close(results)
+			defer wg.Wait()
+
+			for seq := 0; ; seq++ {
+				select {
+				case slots <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+
+				var v In
+				var ok bool
+				select {
+				case v, ok = <-in:
+				case <-ctx.Done():
+					return
+				}
+				if !ok {
+					return
+				}
+
+				wg.Add(1)
+				go func(seq int, v In) {
+					defer wg.Done()
+					res, err := fn(ctx, v)
+					results <- result{seq, res, err}
+				}(seq, v)
+			}
+		}()
+
+		pending := map[int]result{}
+		next := 0
+		var err error
+		for res := range results {
+			if err != nil {
+				continue
+			}
+			pending[res.seq] = res
+			for err == nil {
+				ready, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+
+				err = ready.err
+				if err == nil {
+					err = Send(ctx, out, ready.v)
+				}
+				if err != nil {
+					cancel()
+					break
+				}
+				<-slots
+			}
+		}
+		return err
+	}
+}
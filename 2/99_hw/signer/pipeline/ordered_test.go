@@ -0,0 +1,70 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOrderedMap(t *testing.T) {
+	var inFlight, maxInFlight int32
+	slowFirst := func(ctx context.Context, v int) (int, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		// earlier values finish later, so without the reorder buffer the output is reversed
+		time.Sleep(time.Duration(10-v%10) * time.Millisecond)
+		return v * 10, nil
+	}
+
+	values := make([]int, 30)
+	for i := range values {
+		values[i] = i
+	}
+	result, err := Then(From(values...), OrderedMap(4, slowFirst)).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != len(values) {
+		t.Fatalf("expected %d results, got %v", len(values), result)
+	}
+	for i, v := range result {
+		if v != i*10 {
+			t.Fatalf("results out of order: %v", result)
+		}
+	}
+	if max := atomic.LoadInt32(&maxInFlight); max > 4 || max < 2 {
+		t.Errorf("expected up to 4 values in flight, got %d", max)
+	}
+}
+
+func TestOrderedMapError(t *testing.T) {
+	errBad := errors.New("bad value")
+	failing := func(ctx context.Context, v int) (int, error) {
+		if v == 2 {
+			return 0, errBad
+		}
+		// later values are done before the failed one, but must not be sent
+		time.Sleep(time.Duration(5-v) * time.Millisecond)
+		return v, nil
+	}
+
+	var seen []int
+	err := Then(From(0, 1, 2, 3, 4), OrderedMap(5, failing)).Run(context.Background(), func(v int) error {
+		seen = append(seen, v)
+		return nil
+	})
+	if !errors.Is(err, errBad) {
+		t.Fatalf("expected %v, got %v", errBad, err)
+	}
+	if len(seen) != 2 || seen[0] != 0 || seen[1] != 1 {
+		t.Errorf("expected 0, 1 before the error, got %v", seen)
+	}
+}
@@ -0,0 +1,253 @@
+// Package pipeline is a typed ExecutePipeline: stages are connected with channels,
+// every stage gets a context, and the first error cancels the whole pipeline and is returned from Run
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"runtime/trace"
+	"sync"
+)
+
+// Stage reads values from in until it is closed and sends results to out. out is closed by the pipeline
+// after the stage returns, values should be sent with Send so the stage stops when the pipeline is cancelled
+type Stage[In, Out any] func(ctx context.Context, in <-chan In, out chan<- Out) error
+
+type config struct {
+	buffer   int
+	workers  int
+	stats    *stageStats
+	oneToOne bool
+}
+
+// Option configures one stage of the pipeline
+type Option func(*config)
+
+// Buffer sets the size of the output channel of the stage, by default it is unbuffered
+func Buffer(size int) Option {
+	return func(cfg *config) {
+		cfg.buffer = size
+	}
+}
+
+// Workers runs n copies of the stage reading the same input, the stage must not depend on seeing every value
+func Workers(n int) Option {
+	return func(cfg *config) {
+		cfg.workers = n
+	}
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{workers: 1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.workers < 1 {
+		cfg.workers = 1
+	}
+	if cfg.buffer < 0 {
+		cfg.buffer = 0
+	}
+	return cfg
+}
+
+// group runs the goroutines of one Run, the first error cancels ctx
+type group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+}
+
+func newGroup(ctx context.Context) *group {
+	ctx, cancel := context.WithCancel(ctx)
+	return &group{ctx: ctx, cancel: cancel}
+}
+
+func (g *group) fail(err error) {
+	g.once.Do(func() {
+		g.err = err
+		g.cancel()
+	})
+}
+
+func (g *group) wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+// spawn starts the workers of a stage and closes their output when all of them are done.
+// Whatever is left in the input after that is drained so the previous stages can finish
+func spawn[In, Out any](g *group, cfg config, in <-chan In, fn func(ctx context.Context, in <-chan In, out chan<- Out) error) <-chan Out {
+	out := make(chan Out, cfg.buffer)
+	stageIn, stageOut := in, out
+	if cfg.stats != nil {
+		stageIn = observeIn(g, cfg.stats, cfg.oneToOne, in)
+		stageOut = make(chan Out)
+		observeOut(g, cfg.stats, cfg.oneToOne, stageOut, out)
+	}
+
+	workers := &sync.WaitGroup{}
+	for i := 0; i < cfg.workers; i++ {
+		workers.Add(1)
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			defer workers.Done()
+			runWorker(g.ctx, cfg.stats, func() {
+				if err := fn(g.ctx, stageIn, stageOut); err != nil {
+					g.fail(err)
+				}
+			})
+		}()
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		workers.Wait()
+		close(stageOut)
+		if stageIn != nil {
+			for range stageIn {
+			}
+		}
+	}()
+	return out
+}
+
+// Pipeline is a chain of stages with values of T at the end, nothing runs until Run is called
+type Pipeline[T any] struct {
+	build func(g *group) <-chan T
+}
+
+// Generate starts a pipeline with a stage that has no input
+func Generate[T any](gen func(ctx context.Context, out chan<- T) error, opts ...Option) *Pipeline[T] {
+	cfg := newConfig(opts)
+	return &Pipeline[T]{build: func(g *group) <-chan T {
+		return spawn(g, cfg, nil, func(ctx context.Context, _ <-chan T, out chan<- T) error {
+			return gen(ctx, out)
+		})
+	}}
+}
+
+// From starts a pipeline that sends values one by one
+func From[T any](values ...T) *Pipeline[T] {
+	return Generate(func(ctx context.Context, out chan<- T) error {
+		for _, v := range values {
+			if err := Send(ctx, out, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Then adds a stage to the end of p
+func Then[In, Out any](p *Pipeline[In], stage Stage[In, Out], opts ...Option) *Pipeline[Out] {
+	cfg := newConfig(opts)
+	return &Pipeline[Out]{build: func(g *group) <-chan Out {
+		in := p.build(g)
+		return spawn(g, cfg, in, stage)
+	}}
+}
+
+// Run starts all stages and calls fn for every value that comes out of the pipeline.
+// It returns when all stages are finished, with the first error of a stage or fn
+func (p *Pipeline[T]) Run(ctx context.Context, fn func(T) error) error {
+	ctx, task := trace.NewTask(ctx, "pipeline")
+	defer task.End()
+
+	g := newGroup(ctx)
+	out := p.build(g)
+	for v := range out {
+		if err := fn(v); err != nil {
+			g.fail(err)
+			break
+		}
+	}
+	for range out {
+	}
+	return g.wait()
+}
+
+// Collect runs the pipeline and returns all values that came out of it
+func (p *Pipeline[T]) Collect(ctx context.Context) ([]T, error) {
+	var result []T
+	err := p.Run(ctx, func(v T) error {
+		result = append(result, v)
+		return nil
+	})
+	return result, err
+}
+
+// Send sends v to out unless ctx is cancelled first
+func Send[T any](ctx context.Context, out chan<- T, v T) error {
+	select {
+	case out <- v:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Job turns an old-style job into a typed stage. Values are passed to the job as interface{},
+// and a value of another type than Out coming from the job is an error
+func Job[In, Out any](job func(in, out chan interface{})) Stage[In, Out] {
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		jobIn := make(chan interface{})
+		jobOut := make(chan interface{})
+		jobDone := make(chan struct{})
+
+		go func() {
+			defer close(jobIn)
+			for {
+				select {
+				case v, ok := <-in:
+					if !ok {
+						return
+					}
+					select {
+					case jobIn <- v:
+					case <-jobDone:
+						return
+					case <-ctx.Done():
+						return
+					}
+				case <-jobDone:
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		go func() {
+			defer close(jobOut)
+			defer close(jobDone)
+			job(jobIn, jobOut)
+		}()
+
+		// the job always runs to the end, after an error its output is only drained
+		var err error
+		for v := range jobOut {
+			if err != nil {
+				continue
+			}
+			typed, ok := v.(Out)
+			if !ok && v != nil {
+				err = fmt.Errorf("job sent %T, expected %T", v, typed)
+			} else {
+				err = Send(ctx, out, typed)
+			}
+			if err != nil {
+				cancel()
+			}
+		}
+		return err
+	}
+}
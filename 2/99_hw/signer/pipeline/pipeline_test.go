@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTyped(t *testing.T) {
+	double := func(ctx context.Context, in <-chan int, out chan<- int) error {
+		for v := range in {
+			if err := Send(ctx, out, v*2); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	format := func(ctx context.Context, in <-chan int, out chan<- string) error {
+		for v := range in {
+			if err := Send(ctx, out, strconv.Itoa(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	p := Then(Then(From(1, 2, 3, 4, 5), double, Workers(3), Buffer(2)), format)
+	result, err := p.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(result)
+	if got := len(result); got != 5 {
+		t.Fatalf("expected 5 results, got %v", result)
+	}
+	for i, expected := range []string{"10", "2", "4", "6", "8"} {
+		if result[i] != expected {
+			t.Errorf("expected %v, got %v", expected, result[i])
+		}
+	}
+}
+
+func TestFirstError(t *testing.T) {
+	errBad := errors.New("bad value")
+	var generated int32
+
+	endless := Generate(func(ctx context.Context, out chan<- int) error {
+		for i := 0; ; i++ {
+			atomic.AddInt32(&generated, 1)
+			if err := Send(ctx, out, i); err != nil {
+				return err
+			}
+		}
+	})
+	failing := func(ctx context.Context, in <-chan int, out chan<- int) error {
+		for v := range in {
+			if v == 3 {
+				return errBad
+			}
+			if err := Send(ctx, out, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var seen []int
+	err := Then(endless, failing).Run(context.Background(), func(v int) error {
+		seen = append(seen, v)
+		return nil
+	})
+	if !errors.Is(err, errBad) {
+		t.Fatalf("expected %v, got %v", errBad, err)
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected 0, 1, 2 before the error, got %v", seen)
+	}
+	if atomic.LoadInt32(&generated) == 0 {
+		t.Errorf("generator has not run")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = endless.Run(ctx, func(v int) error {
+		if v == 10 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestJob(t *testing.T) {
+	square := func(in, out chan interface{}) {
+		for v := range in {
+			out <- v.(int) * v.(int)
+		}
+	}
+
+	result, err := Then(From(1, 2, 3), Job[int, int](square)).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 3 || result[0]+result[1]+result[2] != 14 {
+		t.Errorf("expected squares of 1, 2, 3, got %v", result)
+	}
+
+	_, err = Then(From(1, 2, 3), Job[int, string](square)).Collect(context.Background())
+	if err == nil {
+		t.Errorf("expected an error for int sent as string")
+	}
+}
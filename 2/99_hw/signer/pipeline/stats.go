@@ -0,0 +1,219 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"runtime/trace"
+	"sync"
+	"time"
+)
+
+// latencyBounds are the upper bounds of the latency histogram buckets, the last bucket has no bound.
+// Every Stats gets its own copy
+func latencyBounds() []time.Duration {
+	return []time.Duration{
+		time.Millisecond,
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		500 * time.Millisecond,
+		time.Second,
+		5 * time.Second,
+	}
+}
+
+// Histogram counts values in buckets: Counts[i] are the values up to Bounds[i], the last one the values above all bounds
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []int64
+	Count  int64
+	Sum    time.Duration
+}
+
+func newHistogram(bounds []time.Duration) Histogram {
+	return Histogram{Bounds: bounds, Counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(h.Bounds) && d > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+// Mean is the average latency, zero when nothing was observed
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// StageStats is a snapshot of the metrics of one stage.
+// RecvWait is the time the stage waited for input, SendWait is the time the next stage did not take its output.
+// InFlight and Latency pair inputs and outputs in order, so they are counted only for stages marked with OneToOne
+type StageStats struct {
+	Name     string
+	In       int64
+	Out      int64
+	InFlight int64
+	Workers  int64
+	RecvWait time.Duration
+	SendWait time.Duration
+	Latency  Histogram
+}
+
+func (s StageStats) String() string {
+	return fmt.Sprintf("%s: in %d, out %d, in flight %d, workers %d, recv wait %s, send wait %s, latency %s avg",
+		s.Name, s.In, s.Out, s.InFlight, s.Workers, s.RecvWait, s.SendWait, s.Latency.Mean())
+}
+
+// Stats collects the metrics of the stages added with Observe, it can be read while the pipeline runs
+type Stats struct {
+	mu     sync.Mutex
+	stages []*stageStats
+	bounds []time.Duration
+}
+
+func NewStats() *Stats {
+	return &Stats{bounds: latencyBounds()}
+}
+
+// Snapshot returns the metrics of all stages in the order they were added
+func (s *Stats) Snapshot() []StageStats {
+	s.mu.Lock()
+	stages := append([]*stageStats{}, s.stages...)
+	s.mu.Unlock()
+
+	result := make([]StageStats, 0, len(stages))
+	for _, st := range stages {
+		st.mu.Lock()
+		snapshot := st.StageStats
+		snapshot.Latency.Bounds = append([]time.Duration{}, st.Latency.Bounds...)
+		snapshot.Latency.Counts = append([]int64{}, st.Latency.Counts...)
+		st.mu.Unlock()
+		result = append(result, snapshot)
+	}
+	return result
+}
+
+// Observe collects the metrics of the stage into stats under name, and the stage gets runtime/trace regions
+// with the same name, so the pipeline can be looked at with go tool trace. A nil stats is a no-op
+func Observe(stats *Stats, name string) Option {
+	return func(cfg *config) {
+		if stats == nil {
+			return
+		}
+		st := &stageStats{StageStats: StageStats{Name: name, Latency: newHistogram(stats.bounds)}}
+		stats.mu.Lock()
+		stats.stages = append(stats.stages, st)
+		stats.mu.Unlock()
+		cfg.stats = st
+	}
+}
+
+// OneToOne marks an observed stage that sends one output for every input, in the order of input,
+// so its InFlight and Latency are counted. A stage like CombineResults must not be marked
+func OneToOne() Option {
+	return func(cfg *config) {
+		cfg.oneToOne = true
+	}
+}
+
+type stageStats struct {
+	mu sync.Mutex
+	StageStats
+	received []time.Time
+}
+
+func (st *stageStats) update(fn func()) {
+	st.mu.Lock()
+	fn()
+	st.mu.Unlock()
+}
+
+// observeIn passes the values of in to the stage and counts the time spent waiting for them
+func observeIn[In any](g *group, st *stageStats, oneToOne bool, in <-chan In) <-chan In {
+	if in == nil {
+		return nil
+	}
+
+	stageIn := make(chan In)
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer close(stageIn)
+		for {
+			start := time.Now()
+			region := trace.StartRegion(g.ctx, st.Name+" recv")
+			v, ok := <-in
+			region.End()
+			if !ok {
+				return
+			}
+			now := time.Now()
+			st.update(func() {
+				st.In++
+				st.RecvWait += now.Sub(start)
+				if oneToOne {
+					st.InFlight++
+					st.received = append(st.received, now)
+				}
+			})
+
+			if err := Send(g.ctx, stageIn, v); err != nil {
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+	return stageIn
+}
+
+// observeOut passes the values of the stage to out and counts the time spent waiting for the next stage
+func observeOut[Out any](g *group, st *stageStats, oneToOne bool, stageOut <-chan Out, out chan<- Out) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer close(out)
+		for v := range stageOut {
+			now := time.Now()
+			st.update(func() {
+				st.Out++
+				if oneToOne && len(st.received) > 0 {
+					st.InFlight--
+					st.Latency.observe(now.Sub(st.received[0]))
+					st.received = st.received[1:]
+				}
+			})
+
+			region := trace.StartRegion(g.ctx, st.Name+" send")
+			err := Send(g.ctx, out, v)
+			region.End()
+			st.update(func() {
+				st.SendWait += time.Since(now)
+			})
+			if err != nil {
+				for range stageOut {
+				}
+				return
+			}
+		}
+	}()
+}
+
+// runWorker runs one copy of the stage inside a trace region and counts it as a running worker
+func runWorker(ctx context.Context, st *stageStats, fn func()) {
+	if st == nil {
+		fn()
+		return
+	}
+	st.update(func() { st.Workers++ })
+	defer st.update(func() { st.Workers-- })
+	trace.WithRegion(ctx, st.Name, fn)
+}
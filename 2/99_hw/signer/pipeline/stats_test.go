@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	pass := func(ctx context.Context, in <-chan int, out chan<- int) error {
+		for v := range in {
+			if err := Send(ctx, out, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	slow := func(ctx context.Context, in <-chan int, out chan<- int) error {
+		for v := range in {
+			time.Sleep(10 * time.Millisecond)
+			if err := Send(ctx, out, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	stats := NewStats()
+	p := Then(From(1, 2, 3, 4, 5), pass, Observe(stats, "pass"), OneToOne())
+	p = Then(p, slow, Observe(stats, "slow"), OneToOne(), Workers(2))
+	if _, err := p.Collect(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	snapshot := stats.Snapshot()
+	if len(snapshot) != 2 || snapshot[0].Name != "pass" || snapshot[1].Name != "slow" {
+		t.Fatalf("unexpected stages: %v", snapshot)
+	}
+	for _, st := range snapshot {
+		if st.In != 5 || st.Out != 5 || st.InFlight != 0 || st.Workers != 0 || st.Latency.Count != 5 {
+			t.Errorf("unexpected counters: %v", st)
+		}
+	}
+
+	// the slow stage is the bottleneck: the fast one waits to send, the slow one never waits long for input
+	if snapshot[0].SendWait < 10*time.Millisecond {
+		t.Errorf("expected pass to wait for slow, got %v", snapshot[0])
+	}
+	if snapshot[1].Latency.Mean() < 10*time.Millisecond {
+		t.Errorf("expected slow latency over 10ms, got %v", snapshot[1])
+	}
+
+	// the bounds belong to the snapshot, changing them does not touch the running stats
+	latency := snapshot[1].Latency
+	if len(latency.Counts) != len(latency.Bounds)+1 {
+		t.Fatalf("expected a bucket above the last bound, got %v", latency)
+	}
+	var total int64
+	for i, count := range latency.Counts {
+		total += count
+		if i < len(latency.Bounds) && latency.Bounds[i] < 10*time.Millisecond && count != 0 {
+			t.Errorf("expected no latency under 10ms, got %v", latency)
+		}
+	}
+	if total != latency.Count {
+		t.Errorf("expected %d values in the buckets, got %d", latency.Count, total)
+	}
+	latency.Bounds[0] = time.Hour
+	if stats.Snapshot()[1].Latency.Bounds[0] == time.Hour {
+		t.Errorf("snapshot shares the bounds with the stats")
+	}
+}
+
+func TestStatsFanIn(t *testing.T) {
+	sum := func(ctx context.Context, in <-chan int, out chan<- int) error {
+		total := 0
+		for v := range in {
+			total += v
+		}
+		return Send(ctx, out, total)
+	}
+
+	// one output for many inputs does not pair with any of them
+	stats := NewStats()
+	result, err := Then(From(1, 2, 3, 4, 5), sum, Observe(stats, "sum")).Collect(context.Background())
+	if err != nil || len(result) != 1 || result[0] != 15 {
+		t.Fatalf("unexpected result %v, %v", result, err)
+	}
+	st := stats.Snapshot()[0]
+	if st.In != 5 || st.Out != 1 || st.InFlight != 0 || st.Latency.Count != 0 {
+		t.Errorf("unexpected counters: %v", st)
+	}
+	if len(stats.stages[0].received) != 0 {
+		t.Errorf("expected no input times to be kept, got %d", len(stats.stages[0].received))
+	}
+}
@@ -0,0 +1,282 @@
+// Package scheduler is OverheatLock/OverheatUnlock in general form: resources with a concurrency cap,
+// a cooldown after every call, an expected cost of a call and a penalty for going over the cap
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Resource describes something that may only be used by Concurrency calls at a time, 0 means no limit.
+// After a call its slot rests for Cooldown. Cost is how long a call is expected to take, and Penalty is
+// how much longer it takes when run over the cap, like the 1 second overheat of DataSignerMd5.
+// The scheduler lets a call go over the cap only when waiting for a slot is expected to take longer
+// than the penalty, with Penalty 0 the cap is never exceeded
+type Resource struct {
+	Name        string
+	Concurrency int
+	Cooldown    time.Duration
+	Cost        time.Duration
+	Penalty     time.Duration
+}
+
+// ResourceStats is a snapshot of the counters of one resource
+type ResourceStats struct {
+	Calls   int
+	OverCap int
+	Running int
+	Queued  int
+	Waited  time.Duration
+}
+
+type resource struct {
+	Resource
+	free    []time.Time // when every free slot is ready after its cooldown
+	running map[*Reservation]time.Time
+	queue   []*Reservation
+	timer   bool
+	stats   ResourceStats
+}
+
+// Scheduler hands out the resources in the order of requests
+type Scheduler struct {
+	clock     Clock
+	mu        sync.Mutex
+	resources map[string]*resource
+}
+
+var ErrUnknownResource = errors.New("unknown resource")
+
+func New(clock Clock) *Scheduler {
+	return &Scheduler{clock: clock, resources: map[string]*resource{}}
+}
+
+// Add declares a resource, the name must be unique
+func (s *Scheduler) Add(r Resource) error {
+	if r.Name == "" || r.Concurrency < 0 || r.Cooldown < 0 || r.Cost < 0 || r.Penalty < 0 {
+		return fmt.Errorf("bad resource %+v", r)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.resources[r.Name]; ok {
+		return fmt.Errorf("resource %s is already added", r.Name)
+	}
+	s.resources[r.Name] = &resource{
+		Resource: r,
+		free:     make([]time.Time, r.Concurrency),
+		running:  map[*Reservation]time.Time{},
+	}
+	return nil
+}
+
+// MustAdd is Add that panics on error, for resources known at compile time like md5
+func (s *Scheduler) MustAdd(resources ...Resource) {
+	for _, r := range resources {
+		if err := s.Add(r); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Reservation is a place in the queue of a resource, the call may start when Ready is closed
+// and must call Release when it is done. Release of a waiting reservation leaves the queue
+type Reservation struct {
+	s        *Scheduler
+	r        *resource
+	ready    chan struct{}
+	queuedAt time.Time
+	state    int
+	overCap  bool
+}
+
+const (
+	queued = iota
+	running
+	released
+)
+
+func (res *Reservation) Ready() <-chan struct{} {
+	return res.ready
+}
+
+// OverCap tells if the call was let over the concurrency cap and will pay the penalty
+func (res *Reservation) OverCap() bool {
+	res.s.mu.Lock()
+	defer res.s.mu.Unlock()
+	return res.overCap
+}
+
+func (res *Reservation) Release() {
+	s, r := res.s, res.r
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch res.state {
+	case queued:
+		for i, item := range r.queue {
+			if item == res {
+				r.queue = append(r.queue[:i], r.queue[i+1:]...)
+				break
+			}
+		}
+	case running:
+		delete(r.running, res)
+		if !res.overCap && r.Concurrency > 0 {
+			r.free = append(r.free, s.clock.Now().Add(r.Cooldown))
+		}
+	}
+	res.state = released
+	s.dispatch(r)
+}
+
+// Reserve puts a call in the queue of the resource without waiting
+func (s *Scheduler) Reserve(name string) (*Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.resources[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownResource, name)
+	}
+	res := &Reservation{s: s, r: r, ready: make(chan struct{}), queuedAt: s.clock.Now()}
+	r.queue = append(r.queue, res)
+	s.dispatch(r)
+	return res, nil
+}
+
+// Acquire waits for the resource and returns the function that releases it
+func (s *Scheduler) Acquire(ctx context.Context, name string) (func(), error) {
+	res, err := s.Reserve(name)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-res.Ready():
+		return res.Release, nil
+	case <-ctx.Done():
+		res.Release()
+		return nil, ctx.Err()
+	}
+}
+
+// Do runs fn holding the resource
+func (s *Scheduler) Do(ctx context.Context, name string, fn func()) error {
+	release, err := s.Acquire(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer release()
+	fn()
+	return nil
+}
+
+func (s *Scheduler) Stats(name string) (ResourceStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.resources[name]
+	if !ok {
+		return ResourceStats{}, fmt.Errorf("%w %s", ErrUnknownResource, name)
+	}
+	stats := r.stats
+	stats.Running = len(r.running)
+	stats.Queued = len(r.queue)
+	return stats, nil
+}
+
+// dispatch starts the calls at the head of the queue while it can, s.mu must be held
+func (s *Scheduler) dispatch(r *resource) {
+	now := s.clock.Now()
+	for len(r.queue) > 0 {
+		if r.Concurrency == 0 {
+			s.start(r, now, false)
+			continue
+		}
+		if i := r.readySlot(now); i >= 0 {
+			r.free = append(r.free[:i], r.free[i+1:]...)
+			s.start(r, now, false)
+			continue
+		}
+		if r.Penalty > 0 && r.expectedWait(now) > r.Penalty {
+			s.start(r, now, true)
+			continue
+		}
+
+		// nothing to do until a call is released or a slot has cooled down
+		if next, ok := r.nextCooldown(now); ok && !r.timer {
+			r.timer = true
+			s.clock.AfterFunc(next.Sub(now), func() {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				r.timer = false
+				s.dispatch(r)
+			})
+		}
+		return
+	}
+}
+
+func (s *Scheduler) start(r *resource, now time.Time, overCap bool) {
+	res := r.queue[0]
+	r.queue = r.queue[1:]
+	r.running[res] = now
+	res.state = running
+	res.overCap = overCap
+
+	r.stats.Calls++
+	r.stats.Waited += now.Sub(res.queuedAt)
+	if overCap {
+		r.stats.OverCap++
+	}
+	close(res.ready)
+}
+
+func (r *resource) readySlot(now time.Time) int {
+	for i, at := range r.free {
+		if !at.After(now) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *resource) nextCooldown(now time.Time) (time.Time, bool) {
+	var next time.Time
+	for _, at := range r.free {
+		if at.After(now) && (next.IsZero() || at.Before(next)) {
+			next = at
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// expectedWait is when the first slot should be ready: a resting one after its cooldown,
+// or a busy one after the expected end of its call and the cooldown
+func (r *resource) expectedWait(now time.Time) time.Duration {
+	var wait time.Duration = -1
+	for _, at := range r.free {
+		if d := at.Sub(now); wait < 0 || d < wait {
+			wait = d
+		}
+	}
+	for res, started := range r.running {
+		if res.overCap {
+			continue
+		}
+		end := started.Add(r.Cost)
+		if end.Before(now) {
+			end = now
+		}
+		if d := end.Add(r.Cooldown).Sub(now); wait < 0 || d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
@@ -0,0 +1,160 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func isReady(res *Reservation) bool {
+	select {
+	case <-res.Ready():
+		return true
+	default:
+		return false
+	}
+}
+
+func newTest(t *testing.T, r Resource) (*Scheduler, *FakeClock) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := New(clock)
+	if err := s.Add(r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return s, clock
+}
+
+func reserve(t *testing.T, s *Scheduler, name string, n int) []*Reservation {
+	var result []*Reservation
+	for i := 0; i < n; i++ {
+		res, err := s.Reserve(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		result = append(result, res)
+	}
+	return result
+}
+
+func TestConcurrency(t *testing.T) {
+	s, _ := newTest(t, Resource{Name: "crc32", Concurrency: 2})
+	res := reserve(t, s, "crc32", 4)
+	if !isReady(res[0]) || !isReady(res[1]) || isReady(res[2]) || isReady(res[3]) {
+		t.Fatalf("expected only the first two to start")
+	}
+
+	res[1].Release()
+	if !isReady(res[2]) || isReady(res[3]) {
+		t.Fatalf("expected the third to start after a release")
+	}
+
+	// leaving the queue gives the place to the next one
+	res[3].Release()
+	res[0].Release()
+	stats, _ := s.Stats("crc32")
+	if stats.Calls != 3 || stats.Running != 1 || stats.Queued != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	s, clock := newTest(t, Resource{Name: "md5", Concurrency: 1, Cooldown: 100 * time.Millisecond})
+	res := reserve(t, s, "md5", 2)
+	if !isReady(res[0]) || isReady(res[1]) {
+		t.Fatalf("expected only the first to start")
+	}
+
+	res[0].Release()
+	if isReady(res[1]) {
+		t.Fatalf("expected the second to wait for the cooldown")
+	}
+	clock.Advance(99 * time.Millisecond)
+	if isReady(res[1]) {
+		t.Fatalf("expected the second to wait for the cooldown")
+	}
+	clock.Advance(time.Millisecond)
+	if !isReady(res[1]) {
+		t.Fatalf("expected the second to start after the cooldown")
+	}
+
+	stats, _ := s.Stats("md5")
+	if stats.Waited != 100*time.Millisecond {
+		t.Errorf("expected 100ms of waiting, got %s", stats.Waited)
+	}
+}
+
+func TestPenalty(t *testing.T) {
+	// waiting 1s for a slot is worse than 100ms of penalty
+	s, _ := newTest(t, Resource{Name: "cheap", Concurrency: 1, Cost: time.Second, Penalty: 100 * time.Millisecond})
+	res := reserve(t, s, "cheap", 2)
+	if !isReady(res[1]) || !res[1].OverCap() || res[0].OverCap() {
+		t.Errorf("expected the second call to go over the cap")
+	}
+
+	// and waiting 10ms is better than 1s, like DataSignerMd5
+	s, clock := newTest(t, Resource{Name: "md5", Concurrency: 1, Cost: 10 * time.Millisecond, Penalty: time.Second})
+	res = reserve(t, s, "md5", 2)
+	if isReady(res[1]) {
+		t.Fatalf("expected the second call to wait")
+	}
+
+	// the first call runs longer than expected, the second one keeps waiting instead of going over the cap
+	clock.Advance(time.Second)
+	res[0].Release()
+	if !isReady(res[1]) || res[1].OverCap() {
+		t.Errorf("expected the second call to start in the cap")
+	}
+	stats, _ := s.Stats("md5")
+	if stats.OverCap != 0 || stats.Waited != time.Second {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	s, _ := newTest(t, Resource{Name: "md5", Concurrency: 1})
+
+	release, err := s.Acquire(context.Background(), "md5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.Acquire(ctx, "md5"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	release()
+
+	calls := 0
+	if err := s.Do(context.Background(), "md5", func() { calls++ }); err != nil || calls != 1 {
+		t.Errorf("expected one call, got %d, %v", calls, err)
+	}
+	if _, err := s.Acquire(context.Background(), "sha1"); !errors.Is(err, ErrUnknownResource) {
+		t.Errorf("expected %v, got %v", ErrUnknownResource, err)
+	}
+
+	stats, _ := s.Stats("md5")
+	if stats.Calls != 2 || stats.Running != 0 || stats.Queued != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	var fired []int
+	clock.AfterFunc(20*time.Millisecond, func() { fired = append(fired, 2) })
+	clock.AfterFunc(10*time.Millisecond, func() {
+		fired = append(fired, 1)
+		clock.AfterFunc(5*time.Millisecond, func() { fired = append(fired, 3) })
+	})
+
+	clock.Advance(15 * time.Millisecond)
+	if len(fired) != 2 || fired[0] != 1 || fired[1] != 3 || clock.Pending() != 1 {
+		t.Fatalf("unexpected timers %v", fired)
+	}
+	clock.Advance(5 * time.Millisecond)
+	if len(fired) != 3 || clock.Now() != (time.Time{}).Add(20*time.Millisecond) {
+		t.Errorf("unexpected timers %v at %s", fired, clock.Now())
+	}
+}
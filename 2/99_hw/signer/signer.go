@@ -1,78 +1,123 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"hw/hashchain"
+	"hw/pipeline"
+	"hw/scheduler"
+)
+
+// Typed versions of the jobs for hw/pipeline, the results are the same
+var (
+	SingleHashStage     = pipeline.Job[int, string](SingleHash)
+	MultiHashStage      = pipeline.Job[string, string](MultiHash)
+	CombineResultsStage = pipeline.Job[string, string](CombineResults)
 )
 
 func main() {
-	inputData := []int{0, 1, 2, 3, 4, 5, 6}
-	var in = make(chan interface{}, len(inputData))
-	var out = make(chan interface{})
-	for _, i := range inputData {
-		in <- i
+	err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-	close(in)
-	go func() {
-		defer close(out)
-		ExecutePipeline(
-			func(in, out chan interface{}) {
-				for i := range in {
-					out <- i
-				}
-			},
-			SingleHash,
-			MultiHash,
-			CombineResults,
-		)
-	}()
-	for result := range out {
-		fmt.Println("Result:", result)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "signer:", err)
+		os.Exit(1)
 	}
 }
 
 func ExecutePipeline(freeFlowJobs ...job) {
-	var in = make(chan interface{})
-	var wg = &sync.WaitGroup{}
+	ExecuteObservedPipeline(nil, freeFlowJobs...)
+}
 
-	for _, j := range freeFlowJobs {
-		var out = make(chan interface{})
-		wg.Add(1)
-		go func(j job, in, out chan interface{}) {
-			defer wg.Done()
-			j(in, out)
-			close(out)
-		}(j, in, out)
-		in = out
+// ExecuteObservedPipeline is ExecutePipeline that collects the metrics of every job into stats,
+// the jobs are named "job 0", "job 1" and so on. A job may send any number of values, so there is no Latency
+func ExecuteObservedPipeline(stats *pipeline.Stats, freeFlowJobs ...job) {
+	p := pipeline.From[interface{}]()
+	for i, j := range freeFlowJobs {
+		p = pipeline.Then(p, pipeline.Job[interface{}, interface{}](j), pipeline.Observe(stats, fmt.Sprintf("job %d", i)))
 	}
-	wg.Wait()
+	p.Run(context.Background(), func(interface{}) error { return nil })
+}
+
+// newResources returns the limits of the DataSigner functions: md5 overheats for a second when it is called in parallel
+func newResources() *scheduler.Scheduler {
+	s := scheduler.New(scheduler.RealClock)
+	s.MustAdd(scheduler.Resource{Name: "md5", Concurrency: 1, Cost: 10 * time.Millisecond, Penalty: time.Second})
+	return s
+}
+
+// newSigners returns the algorithms of hash chains, md5 and crc32 call DataSignerMd5 and DataSignerCrc32.
+// md5 waits for its resource in resources so it does not overheat
+func newSigners(resources *scheduler.Scheduler) *hashchain.Registry {
+	r := hashchain.NewRegistry()
+	r.MustRegister(
+		hashchain.Algorithm{Name: "md5", Sum: func(data string) (hash string) {
+			resources.Do(context.Background(), "md5", func() { hash = DataSignerMd5(data) })
+			return hash
+		}},
+		hashchain.Algorithm{Name: "crc32", Sum: func(data string) string { return DataSignerCrc32(data) }},
+	)
+	r.MustRegister(hashchain.Standard()...)
+	return r
+}
+
+// Hasher computes SingleHash and MultiHash with the chains parsed from its own registry,
+// so every run has its algorithms and nothing is shared between runs
+type Hasher struct {
+	single *hashchain.Chain
+	multi  *hashchain.Chain
+}
+
+// NewHasher parses the SingleHash and MultiHash formulas with signers, which must have md5 and crc32
+func NewHasher(signers *hashchain.Registry) *Hasher {
+	return &Hasher{
+		single: signers.MustParse("crc32(x)~crc32(md5(x))"),
+		multi:  signers.MustParse("crc32(0+x)+crc32(1+x)+crc32(2+x)+crc32(3+x)+crc32(4+x)+crc32(5+x)"),
+	}
+}
+
+func (h *Hasher) SingleHash(data string) string {
+	return h.single.Sum(data)
+}
+
+func (h *Hasher) MultiHash(data string) string {
+	return h.multi.Sum(data)
+}
+
+// OrderedSingleHash is SingleHash for at most workers values at a time that keeps the order of input
+func (h *Hasher) OrderedSingleHash(workers int) pipeline.Stage[int, string] {
+	return pipeline.OrderedMap(workers, func(ctx context.Context, data int) (string, error) {
+		return h.SingleHash(strconv.Itoa(data)), nil
+	})
+}
+
+// OrderedMultiHash is MultiHash for at most workers values at a time that keeps the order of input,
+// so every hash can be used as soon as it is ready without CombineResults
+func (h *Hasher) OrderedMultiHash(workers int) pipeline.Stage[string, string] {
+	return pipeline.OrderedMap(workers, func(ctx context.Context, data string) (string, error) {
+		return h.MultiHash(data), nil
+	})
 }
 
 func SingleHash(in, out chan interface{}) {
 	var wg = &sync.WaitGroup{}
-	var mutex = &sync.Mutex{}
+	hasher := NewHasher(newSigners(newResources()))
 
 	for inputData := range in {
 		wg.Add(1)
 		go func(inputData interface{}) {
 			defer wg.Done()
-			data := strconv.Itoa(inputData.(int))
-			mutex.Lock()
-			hashMd5 := DataSignerMd5(data)
-			mutex.Unlock()
-
-			var crc32 = make(chan string)
-			go func() {
-				crc32 <- DataSignerCrc32(data)
-			}()
-
-			crc32md5 := DataSignerCrc32(hashMd5)
-			crc32data := <-crc32
-
-			out <- crc32data + "~" + crc32md5
+			out <- hasher.SingleHash(strconv.Itoa(inputData.(int)))
 		}(inputData)
 	}
 	wg.Wait()
@@ -80,24 +125,13 @@ func SingleHash(in, out chan interface{}) {
 
 func MultiHash(in, out chan interface{}) {
 	var wg = &sync.WaitGroup{}
+	hasher := NewHasher(newSigners(newResources()))
 
 	for inputData := range in {
 		wg.Add(1)
 		go func(inputData interface{}) {
 			defer wg.Done()
-			data := inputData.(string)
-			var result [6]string
-			var wgData = &sync.WaitGroup{}
-
-			for i := 0; i < 6; i++ {
-				wgData.Add(1)
-				go func(i int) {
-					defer wgData.Done()
-					result[i] = DataSignerCrc32(strconv.Itoa(i) + data)
-				}(i)
-			}
-			wgData.Wait()
-			out <- strings.Join(result[:], "")
+			out <- hasher.MultiHash(inputData.(string))
 		}(inputData)
 	}
 	wg.Wait()
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"hw/pipeline"
+)
+
+func TestStages(t *testing.T) {
+	// the same values as in the example of readme.md
+	testExpected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+
+	p := pipeline.Then(pipeline.Then(pipeline.Then(pipeline.From(0, 1), SingleHashStage), MultiHashStage), CombineResultsStage)
+	result, err := p.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 1 || result[0] != testExpected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, testExpected)
+	}
+}
+
+func TestOrderedStages(t *testing.T) {
+	// MultiHash results for 0 and 1 from readme.md, in the order of input
+	testExpected := []string{
+		"29568666068035183841425683795340791879727309630931025356555",
+		"4958044192186797981418233587017209679042592862002427381542",
+	}
+
+	hasher := NewHasher(newSigners(newResources()))
+	p := pipeline.Then(pipeline.Then(pipeline.From(0, 1), hasher.OrderedSingleHash(MaxInputDataLen)), hasher.OrderedMultiHash(MaxInputDataLen))
+	result, err := p.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != len(testExpected) || result[0] != testExpected[0] || result[1] != testExpected[1] {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, testExpected)
+	}
+}
+
+func TestObservedPipeline(t *testing.T) {
+	stats := pipeline.NewStats()
+	ExecuteObservedPipeline(stats,
+		job(func(in, out chan interface{}) {
+			for i := 0; i < 3; i++ {
+				out <- i
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				time.Sleep(time.Millisecond * 20)
+				out <- val
+			}
+		}),
+		job(func(in, out chan interface{}) {
+			for range in {
+			}
+		}),
+	)
+
+	snapshot := stats.Snapshot()
+	if len(snapshot) != 3 || snapshot[1].Name != "job 1" {
+		t.Fatalf("unexpected stages: %v", snapshot)
+	}
+	// jobs are not marked OneToOne, the slow one is seen in the recv wait of the job after it
+	if snapshot[1].In != 3 || snapshot[1].Out != 3 || snapshot[1].Latency.Count != 0 || snapshot[2].RecvWait < 20*time.Millisecond {
+		t.Errorf("job 1 should be the slow one: %v, %v", snapshot[1], snapshot[2])
+	}
+}
@@ -0,0 +1,55 @@
+// Package transport carries the values of a pipeline stage over a TCP or Unix socket,
+// so a stage like MultiHash can run in another process
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Every frame is a 4 byte big endian length of the rest, 1 byte kind, 8 byte sequence number and the payload
+const (
+	kindData  byte = 1 // payload is a JSON value
+	kindAck   byte = 2 // the data frame with seq was taken by the receiver
+	kindEnd   byte = 3 // no more data frames, seq is their count
+	kindError byte = 4 // payload is the error that stopped the other side
+
+	headerSize = 1 + 8
+)
+
+// MaxFrameSize limits the payload of one frame
+const MaxFrameSize = 16 << 20
+
+func writeFrame(w io.Writer, kind byte, seq uint64, payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes is over %d", len(payload), MaxFrameSize)
+	}
+	buf := make([]byte, 4+headerSize+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(headerSize+len(payload)))
+	buf[4] = kind
+	binary.BigEndian.PutUint64(buf[5:], seq)
+	copy(buf[4+headerSize:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readFrame(r io.Reader) (kind byte, seq uint64, payload []byte, err error) {
+	var size [4]byte
+	if _, err = io.ReadFull(r, size[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(size[:])
+	if length < headerSize || length-headerSize > MaxFrameSize {
+		return 0, 0, nil, fmt.Errorf("bad frame length %d", length)
+	}
+
+	buf := make([]byte, length)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, nil, err
+	}
+	return buf[0], binary.BigEndian.Uint64(buf[1:]), buf[headerSize:], nil
+}
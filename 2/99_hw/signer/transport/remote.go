@@ -0,0 +1,258 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"hw/pipeline"
+)
+
+// DefaultWindow is how many values may be sent without an ack
+const DefaultWindow = 16
+
+// splitAddr turns tcp:host:port and unix:/path into the network and the address
+func splitAddr(addr string) (string, string, error) {
+	network, address, ok := strings.Cut(addr, ":")
+	if !ok || (network != "tcp" && network != "unix") || address == "" {
+		return "", "", fmt.Errorf("bad address %q, expected tcp:host:port or unix:/path", addr)
+	}
+	return network, address, nil
+}
+
+// Listen listens on tcp:host:port or unix:/path
+func Listen(addr string) (net.Listener, error) {
+	network, address, err := splitAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.Listen(network, address)
+}
+
+// Dialer opens a connection to a worker
+type Dialer func(ctx context.Context) (net.Conn, error)
+
+// Dial connects to the addresses in turn, so the connections are spread between the workers
+func Dial(addrs ...string) (Dialer, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses")
+	}
+	for _, addr := range addrs {
+		if _, _, err := splitAddr(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	var next uint32
+	dialer := &net.Dialer{}
+	return func(ctx context.Context) (net.Conn, error) {
+		addr := addrs[(atomic.AddUint32(&next, 1)-1)%uint32(len(addrs))]
+		network, address, _ := splitAddr(addr)
+		return dialer.DialContext(ctx, network, address)
+	}, nil
+}
+
+// Serve runs stage for every connection accepted from ln: the values of the connection are the input,
+// the output goes back. It returns when ln is closed and all connections are finished
+func Serve[In, Out any](ctx context.Context, ln net.Listener, stage pipeline.Stage[In, Out], opts ...pipeline.Option) error {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveConn(ctx, NewStream[Out, In](conn, DefaultWindow), stage, opts)
+		}()
+	}
+}
+
+func serveConn[In, Out any](ctx context.Context, s *Stream[Out, In], stage pipeline.Stage[In, Out], opts []pipeline.Option) {
+	defer s.Close()
+
+	input := pipeline.Generate(func(ctx context.Context, out chan<- In) error {
+		for {
+			v, err := s.Recv(ctx)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := pipeline.Send(ctx, out, v); err != nil {
+				return err
+			}
+		}
+	})
+	err := pipeline.Then(input, stage, opts...).Run(ctx, func(v Out) error {
+		return s.Send(ctx, v)
+	})
+	if err == nil {
+		err = s.CloseSend(ctx)
+	}
+	if err != nil {
+		s.SendError(err)
+	}
+}
+
+// Remote is a stage that streams its input to a worker started with Serve and sends on what comes back.
+// With pipeline.Workers every copy has its own connection, so the order of values is not kept
+func Remote[In, Out any](dial Dialer, window int) pipeline.Stage[In, Out] {
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		conn, err := dial(ctx)
+		if err != nil {
+			return err
+		}
+		s := NewStream[In, Out](conn, window)
+		defer s.Close()
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		sendErr := make(chan error, 1)
+		go func() {
+			sendErr <- func() error {
+				for {
+					select {
+					case v, ok := <-in:
+						if !ok {
+							return s.CloseSend(ctx)
+						}
+						if err := s.Send(ctx, v); err != nil {
+							return err
+						}
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+			}()
+		}()
+
+		for {
+			v, err := s.Recv(ctx)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				cancel()
+				if e := <-sendErr; e != nil && !errors.Is(e, context.Canceled) {
+					return e
+				}
+				return err
+			}
+			if err := pipeline.Send(ctx, out, v); err != nil {
+				cancel()
+				<-sendErr
+				return err
+			}
+		}
+		return <-sendErr
+	}
+}
+
+// Client calls a worker started with Serve value by value over up to size connections,
+// for a worker stage with one output per input. With pipeline.OrderedMap it keeps the order of values
+type Client[In, Out any] struct {
+	dial  Dialer
+	slots chan struct{}
+	idle  chan *Stream[In, Out]
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func NewClient[In, Out any](dial Dialer, size int) *Client[In, Out] {
+	if size < 1 {
+		size = 1
+	}
+	return &Client[In, Out]{
+		dial:  dial,
+		slots: make(chan struct{}, size),
+		idle:  make(chan *Stream[In, Out], size),
+	}
+}
+
+// Call sends v to a worker and waits for the answer, after Close it returns ErrClosed
+func (c *Client[In, Out]) Call(ctx context.Context, v In) (Out, error) {
+	var result Out
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return result, ErrClosed
+	}
+
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return result, ctx.Err()
+	}
+	defer func() { <-c.slots }()
+
+	var s *Stream[In, Out]
+	select {
+	case s = <-c.idle:
+	default:
+		conn, err := c.dial(ctx)
+		if err != nil {
+			return result, err
+		}
+		s = NewStream[In, Out](conn, 1)
+	}
+
+	err := s.Send(ctx, v)
+	if err == nil {
+		result, err = s.Recv(ctx)
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		s.Close()
+		return result, err
+	}
+
+	// a call that ends after Close ends its connection instead of leaving it idle,
+	// otherwise idle never blocks as there are no more streams than slots
+	c.mu.Lock()
+	closed = c.closed
+	if !closed {
+		c.idle <- s
+	}
+	c.mu.Unlock()
+	if closed {
+		s.CloseSend(context.Background())
+		s.Close()
+	}
+	return result, nil
+}
+
+// Close ends all idle connections, the calls in progress end theirs when they are finished
+func (c *Client[In, Out]) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	var streams []*Stream[In, Out]
+	for len(c.idle) > 0 {
+		streams = append(streams, <-c.idle)
+	}
+	c.mu.Unlock()
+
+	for _, s := range streams {
+		s.CloseSend(context.Background())
+		s.Close()
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// RemoteError is the error of the other side of a stream
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "remote: " + e.Message
+}
+
+var ErrClosed = errors.New("stream is closed")
+
+// MaxWindow limits the window of every stream, a stream fails when the other side sends more frames without acks
+const MaxWindow = 64
+
+type dataFrame struct {
+	seq     uint64
+	payload []byte
+}
+
+// Stream sends values of S and receives values of R over one connection. Every data frame is acked
+// when Recv takes it, and Send waits while window frames are not acked, so a slow receiver slows the sender.
+// Send and Recv may be called from different goroutines, but each of them from one goroutine at a time
+type Stream[S, R any] struct {
+	conn   net.Conn
+	window chan struct{}
+
+	wmu  sync.Mutex
+	sent uint64
+
+	mu      sync.Mutex
+	queue   []dataFrame
+	ended   bool
+	err     error
+	notify  chan struct{}
+	done    chan struct{}
+	closing bool
+}
+
+// NewStream starts reading conn, the stream owns it from now on. The window is from 1 to MaxWindow
+func NewStream[S, R any](conn net.Conn, window int) *Stream[S, R] {
+	if window < 1 {
+		window = 1
+	}
+	if window > MaxWindow {
+		window = MaxWindow
+	}
+	s := &Stream[S, R]{
+		conn:   conn,
+		window: make(chan struct{}, window),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go s.read()
+	return s
+}
+
+func (s *Stream[S, R]) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// read takes frames from the connection, data waits in the queue for Recv. The other side may
+// have another window, but not over MaxWindow, so a longer queue means a broken peer and fails the stream
+func (s *Stream[S, R]) read() {
+	defer close(s.done)
+	for {
+		kind, seq, payload, err := readFrame(s.conn)
+		s.mu.Lock()
+		switch {
+		case err != nil:
+			if s.err == nil {
+				switch {
+				case s.closing:
+					s.err = ErrClosed
+				case err == io.EOF && !s.ended:
+					s.err = io.ErrUnexpectedEOF
+				case err != io.EOF:
+					s.err = err
+				}
+			}
+		case kind == kindData && len(s.queue) >= MaxWindow:
+			s.queue = nil
+			s.err = fmt.Errorf("more than %d frames without acks", MaxWindow)
+		case kind == kindData:
+			s.queue = append(s.queue, dataFrame{seq, payload})
+		case kind == kindAck:
+			select {
+			case <-s.window:
+			default:
+				s.err = fmt.Errorf("unexpected ack %d", seq)
+			}
+		case kind == kindEnd:
+			s.ended = true
+		case kind == kindError:
+			s.err = &RemoteError{string(payload)}
+		default:
+			s.err = fmt.Errorf("unknown frame kind %d", kind)
+		}
+		stop := err != nil || s.err != nil
+		s.mu.Unlock()
+		s.signal()
+		if stop {
+			if err == nil {
+				s.conn.Close()
+			}
+			return
+		}
+	}
+}
+
+// write sends one frame, when the reader has stopped already its error is the real cause of a failed write
+func (s *Stream[S, R]) write(kind byte, seq uint64, payload []byte) error {
+	s.wmu.Lock()
+	err := writeFrame(s.conn, kind, seq, payload)
+	s.wmu.Unlock()
+	if err != nil {
+		s.mu.Lock()
+		if s.err != nil {
+			err = s.err
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *Stream[S, R]) readErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return ErrClosed
+}
+
+// Send writes v when there is room in the window
+func (s *Stream[S, R]) Send(ctx context.Context, v S) error {
+	select {
+	case s.window <- struct{}{}:
+	case <-s.done:
+		return s.readErr()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		<-s.window
+		return err
+	}
+	s.sent++
+	return s.write(kindData, s.sent, payload)
+}
+
+// CloseSend waits for the acks of everything sent and tells the other side there is nothing more
+func (s *Stream[S, R]) CloseSend(ctx context.Context) error {
+	for i := 0; i < cap(s.window); i++ {
+		select {
+		case s.window <- struct{}{}:
+		case <-s.done:
+			return s.readErr()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.write(kindEnd, s.sent, nil)
+}
+
+// SendError stops the other side with err, it gets a RemoteError from Recv
+func (s *Stream[S, R]) SendError(err error) error {
+	return s.write(kindError, 0, []byte(err.Error()))
+}
+
+// Recv returns the next value, io.EOF when the other side called CloseSend
+func (s *Stream[S, R]) Recv(ctx context.Context) (R, error) {
+	var v R
+	for {
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			frame := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+
+			if err := json.Unmarshal(frame.payload, &v); err != nil {
+				return v, err
+			}
+			return v, s.write(kindAck, frame.seq, nil)
+		}
+		ended, err := s.ended, s.err
+		s.mu.Unlock()
+
+		if err != nil {
+			return v, err
+		}
+		if ended {
+			return v, io.EOF
+		}
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return v, ctx.Err()
+		}
+	}
+}
+
+// Close closes the connection and waits for the reader to stop
+func (s *Stream[S, R]) Close() error {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+	err := s.conn.Close()
+	<-s.done
+	return err
+}
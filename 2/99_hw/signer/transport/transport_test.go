@@ -0,0 +1,256 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"hw/pipeline"
+)
+
+func double(ctx context.Context, in <-chan int, out chan<- int) error {
+	for v := range in {
+		if v < 0 {
+			return errors.New("negative value")
+		}
+		if err := pipeline.Send(ctx, out, v*2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startWorker serves stage on addr until the end of the test
+func startWorker(t *testing.T, addr string, stage pipeline.Stage[int, int]) Dialer {
+	ln, err := Listen(addr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(context.Background(), ln, stage)
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		if err := <-done; err != nil {
+			t.Errorf("unexpected serve error: %v", err)
+		}
+	})
+
+	dial, err := Dial(ln.Addr().Network() + ":" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return dial
+}
+
+func TestFrame(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, kindData, 7, []byte("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kind, seq, payload, err := readFrame(&buf)
+	if err != nil || kind != kindData || seq != 7 || string(payload) != "hello" {
+		t.Errorf("unexpected frame %d %d %q %v", kind, seq, payload, err)
+	}
+
+	if _, _, _, err := readFrame(bytes.NewReader([]byte{0, 0, 0, 1, 1})); err == nil {
+		t.Errorf("expected an error for a short frame")
+	}
+	if _, _, _, err := readFrame(bytes.NewReader([]byte{0, 0, 0, 20, 1, 0})); err == nil {
+		t.Errorf("expected an error for a cut frame")
+	}
+}
+
+func TestRemote(t *testing.T) {
+	dial := startWorker(t, "tcp:127.0.0.1:0", double)
+
+	values := make([]int, 100)
+	for i := range values {
+		values[i] = i
+	}
+	p := pipeline.Then(pipeline.From(values...), Remote[int, int](dial, 4), pipeline.Workers(3))
+	result, err := p.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sort.Ints(result)
+	if len(result) != len(values) {
+		t.Fatalf("expected %d results, got %d", len(values), len(result))
+	}
+	for i, v := range result {
+		if v != i*2 {
+			t.Fatalf("unexpected results %v", result)
+		}
+	}
+}
+
+func TestRemoteError(t *testing.T) {
+	dial := startWorker(t, "tcp:127.0.0.1:0", double)
+
+	_, err := pipeline.Then(pipeline.From(1, 2, -3, 4), Remote[int, int](dial, 1)).Collect(context.Background())
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) || !strings.Contains(remoteErr.Message, "negative value") {
+		t.Errorf("expected a remote error, got %v", err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	dial := startWorker(t, "unix:"+filepath.Join(t.TempDir(), "worker.sock"), double)
+	client := NewClient[int, int](dial, 4)
+	defer client.Close()
+
+	values := make([]int, 50)
+	for i := range values {
+		values[i] = i
+	}
+	result, err := pipeline.Then(pipeline.From(values...), pipeline.OrderedMap(8, client.Call)).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, v := range result {
+		if v != i*2 {
+			t.Fatalf("results out of order: %v", result)
+		}
+	}
+
+	if _, err := client.Call(context.Background(), -1); err == nil {
+		t.Errorf("expected an error for a negative value")
+	}
+	if v, err := client.Call(context.Background(), 21); err != nil || v != 42 {
+		t.Errorf("expected 42 after a failed call, got %d, %v", v, err)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	release := make(chan struct{})
+	dial := startWorker(t, "unix:"+filepath.Join(t.TempDir(), "worker.sock"), func(ctx context.Context, in <-chan int, out chan<- int) error {
+		for v := range in {
+			<-release
+			if err := pipeline.Send(ctx, out, v*2); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	conns := make(chan net.Conn, 1)
+	client := NewClient[int, int](func(ctx context.Context) (net.Conn, error) {
+		conn, err := dial(ctx)
+		if err == nil {
+			conns <- conn
+		}
+		return conn, err
+	}, 1)
+
+	result := make(chan error, 1)
+	go func() {
+		v, err := client.Call(context.Background(), 21)
+		if err == nil && v != 42 {
+			err = errors.New("unexpected value")
+		}
+		result <- err
+	}()
+
+	// the call has its connection, Close does not see it in the idle ones
+	conn := <-conns
+	client.Close()
+	close(release)
+	if err := <-result; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := conn.SetDeadline(time.Now()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected the connection of the call to be closed, got %v", err)
+	}
+	if _, err := client.Call(context.Background(), 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected %v after Close, got %v", ErrClosed, err)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	ln, err := Listen("tcp:127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sender := NewStream[int, int](conn, 2)
+	defer sender.Close()
+	receiver := NewStream[int, int](<-accepted, 2)
+	defer receiver.Close()
+
+	ctx := context.Background()
+	for i := 1; i <= 2; i++ {
+		if err := sender.Send(ctx, i); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// nothing is acked yet, so the third value has to wait
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := sender.Send(timeout, 3); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the window to be full, got %v", err)
+	}
+
+	if v, err := receiver.Recv(ctx); err != nil || v != 1 {
+		t.Fatalf("expected 1, got %d, %v", v, err)
+	}
+	if err := sender.Send(ctx, 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sender.CloseSend(ctx)
+	}()
+	for _, expected := range []int{2, 3} {
+		if v, err := receiver.Recv(ctx); err != nil || v != expected {
+			t.Fatalf("expected %d, got %d, %v", expected, v, err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := receiver.Recv(ctx); err == nil || err.Error() != "EOF" {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestWindowOverflow(t *testing.T) {
+	conn, peer := net.Pipe()
+	receiver := NewStream[int, int](conn, 1)
+	defer receiver.Close()
+
+	// a broken peer that ignores the window and never waits for acks
+	go func() {
+		for i := 1; i <= MaxWindow+1; i++ {
+			if err := writeFrame(peer, kindData, uint64(i), []byte("1")); err != nil {
+				return
+			}
+		}
+	}()
+	<-receiver.done
+	peer.Close()
+
+	if _, err := receiver.Recv(context.Background()); err == nil || !strings.Contains(err.Error(), "without acks") {
+		t.Errorf("expected the stream to fail, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// aggregateNames are the allowed query params in the order their results are built
+const aggregateNames = "count,sum,avg,min,max"
+
+// aggregateFunc is the sql function of a query param, numeric ones only work on integer and float columns
+func aggregateFunc(name string) (sqlName string, numeric bool) {
+	switch name {
+	case "count":
+		return "COUNT", false
+	case "sum":
+		return "SUM", true
+	case "avg":
+		return "AVG", true
+	case "min":
+		return "MIN", false
+	case "max":
+		return "MAX", false
+	}
+	return "", false
+}
+
+type aggregate struct {
+	Func    string
+	SQL     string
+	Column  string
+	Numeric bool
+}
+
+// alias is the key of the result in a group: count for count=*, otherwise func_column
+func (a aggregate) alias() string {
+	if a.Column == "*" {
+		return a.Func
+	}
+	return a.Func + "_" + a.Column
+}
+
+type aggregateQuery struct {
+	Group       []string
+	Aggregates  []aggregate
+	Where       []condition
+	HideDeleted bool
+}
+
+func parseAggregateQuery(table Table, params url.Values) (aggregateQuery, error) {
+	aq := aggregateQuery{}
+
+	if g := params.Get("group"); g != "" {
+		for _, name := range strings.Split(g, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := table.findColumn(name); !ok {
+				return aq, fmt.Errorf("unknown column %s", name)
+			}
+			aq.Group = append(aq.Group, name)
+		}
+	}
+
+	for _, fn := range strings.Split(aggregateNames, ",") {
+		raw := params.Get(fn)
+		if raw == "" {
+			continue
+		}
+		sqlName, numeric := aggregateFunc(fn)
+		for _, name := range strings.Split(raw, ",") {
+			name = strings.TrimSpace(name)
+			agg := aggregate{Func: fn, SQL: sqlName, Column: name, Numeric: numeric}
+			if name == "*" && fn == "count" {
+				aq.Aggregates = append(aq.Aggregates, agg)
+				continue
+			}
+
+			col, ok := table.findColumn(name)
+			if !ok {
+				return aq, fmt.Errorf("unknown column %s", name)
+			}
+			if ct := parseColumnType(col.Type); numeric && !ct.isInteger() && !ct.isFloat() {
+				return aq, fmt.Errorf("column %s is not numeric", name)
+			}
+			aq.Aggregates = append(aq.Aggregates, agg)
+		}
+	}
+	if len(aq.Aggregates) == 0 {
+		return aq, fmt.Errorf("no aggregates")
+	}
+
+	where, err := parseWhere(table, params)
+	if err != nil {
+		return aq, err
+	}
+	aq.Where = where
+	aq.HideDeleted = table.softDelete() && params.Get("with_deleted") != "1"
+	return aq, nil
+}
+
+func (aq aggregateQuery) columns() []string {
+	columns := append([]string{}, aq.Group...)
+	for _, agg := range aq.Aggregates {
+		if agg.Column != "*" {
+			columns = append(columns, agg.Column)
+		}
+	}
+	for _, c := range aq.Where {
+		columns = append(columns, c.Column)
+	}
+	return columns
+}
+
+func (aq aggregateQuery) sql(d Dialect, tableName string) (string, []interface{}) {
+	var selects, groups []string
+	for _, name := range aq.Group {
+		selects = append(selects, d.Quote(name))
+		groups = append(groups, d.Quote(name))
+	}
+	for _, agg := range aq.Aggregates {
+		column := agg.Column
+		if column != "*" {
+			column = d.Quote(column)
+		}
+		selects = append(selects, fmt.Sprintf("%s(%s) AS %s", agg.SQL, column, d.Quote(agg.alias())))
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ", "), d.Quote(tableName))
+	conds, args := conditionsSQL(d, aq.Where)
+	if aq.HideDeleted {
+		conds = append(conds, d.Quote(deletedAtColumn)+" IS NULL")
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	if len(groups) > 0 {
+		query += " GROUP BY " + strings.Join(groups, ", ") + " ORDER BY " + strings.Join(groups, ", ")
+	}
+	return query, args
+}
+
+// normalize turns numeric results that drivers return as text (mysql DECIMAL sums) into numbers
+func (aq aggregateQuery) normalize(group map[string]interface{}) {
+	for _, agg := range aq.Aggregates {
+		if s, ok := group[agg.alias()].(string); ok && agg.Numeric {
+			if num, err := strconv.ParseFloat(s, 64); err == nil {
+				group[agg.alias()] = num
+			}
+		}
+	}
+}
+
+func (dbe *DBExplorer) runAggregate(r *http.Request, tableName string, params url.Values) ([]map[string]interface{}, error) {
+	acc := accessFrom(r.Context())
+	if err := acc.canRead(tableName); err != nil {
+		return nil, &apiError{403, err.Error()}
+	}
+
+	table, ok := dbe.table(tableName)
+	if !ok {
+		return nil, &apiError{404, "unknown table"}
+	}
+
+	aq, err := parseAggregateQuery(table, params)
+	if err != nil {
+		return nil, &apiError{400, err.Error()}
+	}
+	if err := acc.checkColumns(tableName, aq.columns()); err != nil {
+		return nil, &apiError{403, err.Error()}
+	}
+
+	query, args := aq.sql(dbe.dialect, tableName)
+	rows, err := dbe.conn(r.Context()).Query(dbe.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := dbe.readRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		aq.normalize(group)
+	}
+	return groups, nil
+}
+
+func (dbe *DBExplorer) aggregateData(w http.ResponseWriter, r *http.Request, tableName string) {
+	groups, err := dbe.runAggregate(r, tableName, r.URL.Query())
+	if err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+	if groups == nil {
+		groups = []map[string]interface{}{}
+	}
+
+	var response = map[string]interface{}{
+		"response": map[string]interface{}{
+			"groups": groups,
+		},
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// countData is _aggregate?count=* without groups, filters are the same as for the list
+func (dbe *DBExplorer) countData(w http.ResponseWriter, r *http.Request, tableName string) {
+	params := url.Values{}
+	for key, values := range r.URL.Query() {
+		if strings.HasPrefix(key, "where[") || key == "with_deleted" {
+			params[key] = values
+		}
+	}
+	params.Set("count", "*")
+
+	groups, err := dbe.runAggregate(r, tableName, params)
+	if err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+
+	var response = map[string]interface{}{
+		"response": map[string]interface{}{
+			"count": groups[0]["count"],
+		},
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WithAudit records every insert, update and delete into tableName, the table is created if it does not exist.
+// The audit table itself is not served by the explorer
+func WithAudit(tableName string) Option {
+	return func(dbe *DBExplorer) {
+		dbe.audit = tableName
+	}
+}
+
+type auditEntry struct {
+	Action    string                 `json:"action"`
+	Actor     string                 `json:"actor"`
+	Changes   map[string]auditChange `json:"changes"`
+	CreatedAt string                 `json:"created_at"`
+}
+
+type auditChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+func (dbe *DBExplorer) createAuditTable() error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id %s,
+		table_name VARCHAR(255) NOT NULL,
+		record_key VARCHAR(255) NOT NULL,
+		action VARCHAR(16) NOT NULL,
+		actor VARCHAR(255) NOT NULL,
+		changes TEXT NOT NULL,
+		created_at VARCHAR(32) NOT NULL
+	)`, dbe.dialect.Quote(dbe.audit), dbe.dialect.SerialKey())
+	_, err := dbe.db.Exec(query)
+	return err
+}
+
+// actor identifies who made the request without storing the token itself
+func (acc *access) actor() string {
+	if acc == nil {
+		return ""
+	}
+	if acc.token == "" {
+		return acc.roleName
+	}
+	sum := sha256.Sum256([]byte(acc.token))
+	return acc.roleName + ":" + hex.EncodeToString(sum[:6])
+}
+
+// auditBefore reads the row before a change, it is a no-op when the audit is disabled
+func (dbe *DBExplorer) auditBefore(q queryer, table Table, key recordKey) (map[string]interface{}, error) {
+	if dbe.audit == "" {
+		return nil, nil
+	}
+	return dbe.fetchRecord(q, table, key)
+}
+
+// auditWrite stores the difference between before and the current state of the row. When the row appears
+// or disappears all columns are stored, otherwise only the changed ones
+func (dbe *DBExplorer) auditWrite(q queryer, acc *access, action string, table Table, key recordKey, before map[string]interface{}) error {
+	// a row without a primary key can not be read back to compare
+	if dbe.audit == "" || len(table.PrimaryKey) == 0 {
+		return nil
+	}
+
+	after, err := dbe.fetchRecord(q, table, key)
+	if err != nil {
+		return err
+	}
+	if before == nil && after == nil {
+		return nil
+	}
+
+	changes := map[string]auditChange{}
+	for _, col := range table.Columns {
+		change := auditChange{Old: before[col.Name], New: after[col.Name]}
+		if fmt.Sprint(change.Old) != fmt.Sprint(change.New) || before == nil || after == nil {
+			changes[col.Name] = change
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	keyJSON, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (table_name, record_key, action, actor, changes, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		dbe.dialect.Quote(dbe.audit))
+	_, err = q.Exec(dbe.dialect.Rebind(query), table.Name, string(keyJSON), action, acc.actor(), string(changesJSON),
+		time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+func (dbe *DBExplorer) showHistory(w http.ResponseWriter, r *http.Request, tableName, idStr string) {
+	acc := accessFrom(r.Context())
+	if err := acc.canRead(tableName); err != nil {
+		dbe.sendError(w, err.Error(), 403)
+		return
+	}
+
+	table, ok := dbe.table(tableName)
+	if !ok {
+		dbe.sendError(w, "unknown table", 404)
+		return
+	}
+
+	if dbe.audit == "" {
+		dbe.sendError(w, "audit is disabled", 404)
+		return
+	}
+
+	key, err := parseKey(table, idStr, r.URL.Query())
+	if err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+	keyJSON, _ := json.Marshal(key)
+
+	query := fmt.Sprintf("SELECT action, actor, changes, created_at FROM %s WHERE table_name = ? AND record_key = ? ORDER BY id",
+		dbe.dialect.Quote(dbe.audit))
+	rows, err := dbe.conn(r.Context()).Query(dbe.dialect.Rebind(query), tableName, string(keyJSON))
+	if err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+	defer rows.Close()
+
+	history := []auditEntry{}
+	for rows.Next() {
+		var entry auditEntry
+		var changes string
+		if err := rows.Scan(&entry.Action, &entry.Actor, &changes, &entry.CreatedAt); err != nil {
+			dbe.sendFailure(w, err)
+			return
+		}
+		if err := json.Unmarshal([]byte(changes), &entry.Changes); err != nil {
+			dbe.sendFailure(w, err)
+			return
+		}
+		for name := range entry.Changes {
+			if acc.isHidden(tableName, name) {
+				delete(entry.Changes, name)
+			}
+		}
+		history = append(history, entry)
+	}
+	if err := rows.Err(); err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+
+	var response = map[string]interface{}{
+		"response": map[string]interface{}{
+			"history": history,
+		},
+	}
+	json.NewEncoder(w).Encode(response)
+}
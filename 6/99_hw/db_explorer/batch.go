@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type batchOperation struct {
+	Op    string                 `json:"op"`
+	Table string                 `json:"table"`
+	ID    interface{}            `json:"id"`
+	Data  map[string]interface{} `json:"data"`
+}
+
+type batchRequest struct {
+	Operations []batchOperation `json:"operations"`
+}
+
+// runBatch executes all operations in one transaction and rolls everything back on the first error
+func (dbe *DBExplorer) runBatch(w http.ResponseWriter, r *http.Request) {
+	var batch batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		dbe.sendError(w, "invalid json", 400)
+		return
+	}
+
+	var tableNames []string
+	for _, op := range batch.Operations {
+		if _, ok := dbe.table(op.Table); ok {
+			tableNames = append(tableNames, op.Table)
+		}
+	}
+	release, err := dbe.acquire(r.Context(), tableNames...)
+	if err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+	defer release()
+
+	acc := accessFrom(r.Context())
+	results := make([]map[string]interface{}, 0, len(batch.Operations))
+	err = dbe.inTx(r.Context(), func(tx queryer) error {
+		for i, op := range batch.Operations {
+			result := map[string]interface{}{
+				"op":    op.Op,
+				"table": op.Table,
+			}
+			results = append(results, result)
+
+			if err := dbe.runOperation(tx, acc, op, result, withDeleted(r)); err != nil {
+				result["error"] = err.Error()
+				return &indexedError{i, err}
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		// nothing was written, so the results of the operations before the failed one only say so
+		for i, result := range results {
+			if _, failed := result["error"]; !failed {
+				results[i] = map[string]interface{}{
+					"op":          result["op"],
+					"table":       result["table"],
+					"rolled_back": true,
+				}
+			}
+		}
+
+		message := err.Error()
+		var ie *indexedError
+		if errors.As(err, &ie) {
+			message = fmt.Sprintf("operation %d: %s", ie.Index, ie.Err)
+		}
+
+		w.WriteHeader(errorCode(err))
+		response := map[string]interface{}{
+			"error":   message,
+			"results": results,
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	var response = map[string]interface{}{
+		"response": map[string]interface{}{
+			"results": results,
+		},
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (dbe *DBExplorer) runOperation(tx queryer, acc *access, op batchOperation, result map[string]interface{}, withDeleted bool) error {
+	if err := acc.checkWrite(op.Table, op.Data); err != nil {
+		return &apiError{403, err.Error()}
+	}
+	table, ok := dbe.table(op.Table)
+	if !ok {
+		return &apiError{404, "unknown table"}
+	}
+
+	switch op.Op {
+	case "create":
+		key, err := dbe.insertRecord(tx, acc, table, op.Data)
+		if err != nil {
+			return err
+		}
+		for name, value := range key.fields(table) {
+			result[name] = value
+		}
+
+	case "update":
+		key, err := keyFromValue(table, op.ID)
+		if err != nil {
+			return err
+		}
+		affected, err := dbe.updateRecord(tx, acc, table, key, op.Data, withDeleted)
+		if err != nil {
+			return err
+		}
+		result["updated"] = affected
+
+	case "delete":
+		key, err := keyFromValue(table, op.ID)
+		if err != nil {
+			return err
+		}
+		affected, err := dbe.deleteRecord(tx, acc, table, key)
+		if err != nil {
+			return err
+		}
+		result["deleted"] = affected
+
+	default:
+		return &apiError{400, fmt.Sprintf("unknown operation %s", op.Op)}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type cursor struct {
+	Order  string        `json:"o"`
+	Values []interface{} `json:"v"`
+}
+
+func (lq listQuery) keysetSignature() string {
+	parts := make([]string, 0, len(lq.Keyset))
+	for _, o := range lq.Keyset {
+		if o.Desc {
+			parts = append(parts, "-"+o.Column)
+		} else {
+			parts = append(parts, o.Column)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// cursorable is false when the table has no primary key or the page is sorted by a nullable column,
+// NULLs do not compare with > and < so keyset pages would lose rows. Key columns are taken as not null
+// even where sqlite reports them nullable
+func (lq listQuery) cursorable(table Table) bool {
+	if len(table.PrimaryKey) == 0 {
+		return false
+	}
+	for _, o := range lq.Keyset {
+		if col, _ := table.findColumn(o.Column); col.IsNullable && !col.IsPrimaryKey {
+			return false
+		}
+	}
+	return true
+}
+
+func (lq listQuery) keysetColumns() []string {
+	columns := make([]string, 0, len(lq.Keyset))
+	for _, o := range lq.Keyset {
+		columns = append(columns, o.Column)
+	}
+	return columns
+}
+
+// storedValue turns a datetime into the text the column keeps: sqlite reads DATETIME back as time.Time,
+// which json writes as RFC 3339, but the keyset condition compares it with the stored text
+func storedValue(table Table, column string, v interface{}) interface{} {
+	col, _ := table.findColumn(column)
+	ct := parseColumnType(col.Type)
+	if !ct.isDateTime() {
+		return v
+	}
+	switch t := v.(type) {
+	case time.Time:
+		return ct.formatDateTime(t)
+	case string:
+		if parsed, ok := parseDateTime(t); ok {
+			return ct.formatDateTime(parsed)
+		}
+	}
+	return v
+}
+
+func (lq listQuery) encodeCursor(table Table, record map[string]interface{}) string {
+	c := cursor{Order: lq.keysetSignature()}
+	for _, o := range lq.Keyset {
+		c.Values = append(c.Values, storedValue(table, o.Column, record[o.Column]))
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (lq listQuery) decodeCursor(table Table, raw string) ([]interface{}, error) {
+	if !lq.cursorable(table) {
+		return nil, fmt.Errorf("cursor pagination needs a primary key and non-nullable order columns")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	var c cursor
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&c); err != nil || c.Order != lq.keysetSignature() || len(c.Values) != len(lq.Keyset) {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	for i, v := range c.Values {
+		num, ok := v.(json.Number)
+		if !ok {
+			c.Values[i] = storedValue(table, lq.Keyset[i].Column, v)
+			continue
+		}
+		if n, err := num.Int64(); err == nil {
+			c.Values[i] = n
+		} else if f, err := num.Float64(); err == nil {
+			c.Values[i] = f
+		}
+	}
+	return c.Values, nil
+}
+
+// keysetCondition builds (a > ?) OR (a = ? AND b > ?) ..., with < for DESC columns
+func (lq listQuery) keysetCondition(d Dialect) (string, []interface{}) {
+	var ors []string
+	var args []interface{}
+	for i, o := range lq.Keyset {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, d.Quote(lq.Keyset[j].Column)+" = ?")
+			args = append(args, lq.After[j])
+		}
+		op := " > ?"
+		if o.Desc {
+			op = " < ?"
+		}
+		ands = append(ands, d.Quote(o.Column)+op)
+		args = append(args, lq.After[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
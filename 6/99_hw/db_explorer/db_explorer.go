@@ -1,126 +1,272 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"strconv"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type DBExplorer struct {
-	db     *sql.DB
-	tables map[string]Table
+	db      *sql.DB
+	dialect Dialect
+	tables  atomic.Pointer[map[string]Table]
+	policy  *Policy
+	audit   string
+
+	queryTimeout time.Duration
+	tableLimit   int
+	slots        map[string]chan struct{}
+	slotsMu      sync.Mutex
+
+	reloadInterval time.Duration
+	stop           chan struct{}
+	closeOnce      sync.Once
+	watching       sync.WaitGroup
+	// loadMu serializes schema loads, so a slow reload can not overwrite a newer schema with an older one
+	loadMu sync.Mutex
 }
 type Table struct {
-	Name       string
-	Columns    []Column
-	PrimaryKey string
+	Name        string       `json:"name"`
+	Columns     []Column     `json:"columns"`
+	PrimaryKey  []string     `json:"primary_key"`
+	Indexes     []Index      `json:"indexes"`
+	ForeignKeys []ForeignKey `json:"foreign_keys"`
 }
 type Column struct {
-	Name         string
-	Type         string
-	IsNullable   bool
-	IsPrimaryKey bool
+	Name         string  `json:"name"`
+	Type         string  `json:"type"`
+	IsNullable   bool    `json:"nullable"`
+	IsPrimaryKey bool    `json:"primary_key"`
+	Default      *string `json:"default"`
+	Extra        string  `json:"extra"`
+	Comment      string  `json:"comment"`
+	Collation    string  `json:"collation"`
+	// KeyPosition is the place of the column in the primary key starting from 1, 0 for the other columns
+	KeyPosition int `json:"-"`
+	// MaxBytes is the size of a mysql text column, 0 where text has no limit
+	MaxBytes int `json:"-"`
 }
 
-func NewDbExplorer(db *sql.DB) (http.Handler, error) {
+// Option configures optional DBExplorer behaviour
+type Option func(*DBExplorer)
+
+// WithReloadInterval makes the explorer re-read the schema in background every interval
+func WithReloadInterval(interval time.Duration) Option {
+	return func(dbe *DBExplorer) {
+		dbe.reloadInterval = interval
+	}
+}
+
+func NewDbExplorer(db *sql.DB, opts ...Option) (http.Handler, error) {
+	dialect, err := detectDialect(db)
+	if err != nil {
+		return nil, err
+	}
+
 	explorer := &DBExplorer{
-		db:     db,
-		tables: make(map[string]Table),
+		db:      db,
+		dialect: dialect,
+		slots:   map[string]chan struct{}{},
+		stop:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(explorer)
+	}
+
+	if explorer.audit != "" {
+		if err := explorer.createAuditTable(); err != nil {
+			return nil, err
+		}
 	}
 
-	err := explorer.loadTable()
+	err = explorer.loadTable(context.Background())
 	if err != nil {
 		return nil, err
 	}
+
+	if explorer.reloadInterval > 0 {
+		explorer.watching.Add(1)
+		go explorer.watchSchema()
+	}
 	return explorer, nil
 }
 
-func (dbe *DBExplorer) loadTable() error {
-	rows, err := dbe.db.Query("SHOW TABLES")
+// loadTable reads the whole schema and swaps it in at once, so requests see either the old or the new one
+func (dbe *DBExplorer) loadTable(ctx context.Context) error {
+	dbe.loadMu.Lock()
+	defer dbe.loadMu.Unlock()
+
+	q := dbe.conn(ctx)
+	tableNames, err := dbe.dialect.Tables(q)
 	if err != nil {
 		return err
 	}
 
-	var tableNames []string
-	for rows.Next() {
-		var name string
-		rows.Scan(&name)
-		tableNames = append(tableNames, name)
-	}
-
+	tables := make(map[string]Table, len(tableNames))
 	for _, tableName := range tableNames {
-		table, err := dbe.getTableStructure(tableName)
+		if tableName == dbe.audit {
+			continue
+		}
+		table, err := dbe.getTableStructure(q, tableName)
 		if err != nil {
 			return err
 		}
-		dbe.tables[tableName] = table
+		tables[tableName] = table
 	}
+
+	dbe.tables.Store(&tables)
 	return nil
 }
 
-func (dbe *DBExplorer) getTableStructure(tableName string) (Table, error) {
+func (dbe *DBExplorer) schema() map[string]Table {
+	return *dbe.tables.Load()
+}
+
+func (dbe *DBExplorer) table(name string) (Table, bool) {
+	table, ok := dbe.schema()[name]
+	return table, ok
+}
+
+func (dbe *DBExplorer) getTableStructure(q queryer, tableName string) (Table, error) {
 	table := Table{
-		Name:    tableName,
-		Columns: []Column{},
+		Name: tableName,
 	}
 
-	query := fmt.Sprintf("SHOW FULL COLUMNS FROM `%s`", tableName)
-	rows, err := dbe.db.Query(query)
+	var err error
+	table.Columns, err = dbe.dialect.Columns(q, tableName)
 	if err != nil {
 		return table, err
 	}
-
-	for rows.Next() {
-		var field, colType, collation, null, key, defaultVal, extra, privileges, comment sql.NullString
-		rows.Scan(&field, &colType, &collation, &null, &key, &defaultVal, &extra, &privileges, &comment)
-
-		column := Column{
-			Name:         field.String,
-			Type:         colType.String,
-			IsNullable:   null.String == "YES",
-			IsPrimaryKey: key.String == "PRI",
-		}
-		table.Columns = append(table.Columns, column)
-
+	var keyColumns []Column
+	for _, column := range table.Columns {
 		if column.IsPrimaryKey {
-			table.PrimaryKey = column.Name
+			keyColumns = append(keyColumns, column)
 		}
 	}
+	// the key order is the one of PRIMARY KEY (...), it may differ from the column order
+	sort.SliceStable(keyColumns, func(i, j int) bool {
+		return keyColumns[i].KeyPosition < keyColumns[j].KeyPosition
+	})
+	for _, column := range keyColumns {
+		table.PrimaryKey = append(table.PrimaryKey, column.Name)
+	}
+
+	table.Indexes, err = dbe.dialect.Indexes(q, tableName)
+	if err != nil {
+		return table, err
+	}
+	table.ForeignKeys, err = dbe.dialect.ForeignKeys(q, tableName)
+	if err != nil {
+		return table, err
+	}
 	return table, nil
 }
 
+func (c Column) isAutoIncrement() bool {
+	return strings.Contains(strings.ToLower(c.Extra), "auto_increment")
+}
+
 func (dbe *DBExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	acc, err := dbe.resolveAccess(r)
+	if err != nil {
+		dbe.sendError(w, err.Error(), 401)
+		return
+	}
+	ctx := context.WithValue(r.Context(), accessKey{}, acc)
+	if dbe.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dbe.queryTimeout)
+		defer cancel()
+	}
+	r = r.WithContext(ctx)
+
 	path := strings.Trim(r.URL.Path, "/")
 	splitPath := strings.Split(path, "/")
 
 	tableName := splitPath[0]
 
+	if table, ok := dbe.table(tableName); ok {
+		// expanded relations are queried by the same request, a bad ?expand= is reported by the handler
+		tableNames := []string{tableName}
+		if fks, err := parseExpand(table, r.URL.Query().Get("expand")); err == nil {
+			for _, fk := range fks {
+				tableNames = append(tableNames, fk.RefTable)
+			}
+		}
+		release, err := dbe.acquire(ctx, tableNames...)
+		if err != nil {
+			dbe.sendFailure(w, err)
+			return
+		}
+		defer release()
+	}
+
 	switch r.Method {
 	case "GET":
-		if len(splitPath) == 1 {
-			dbe.showData(w, r, tableName)
+		if tableName == "" {
+			dbe.showTable(w, r)
+		} else if tableName == "_openapi.json" {
+			dbe.showOpenAPI(w, r)
+		} else if len(splitPath) == 1 {
+			dbe.showData(w, r, tableName, false)
+		} else if splitPath[1] == "_schema" {
+			dbe.showSchema(w, r, tableName)
+		} else if splitPath[1] == "_search" {
+			dbe.showData(w, r, tableName, true)
+		} else if splitPath[1] == "_export" {
+			dbe.exportData(w, r, tableName)
+		} else if splitPath[1] == "_aggregate" {
+			dbe.aggregateData(w, r, tableName)
+		} else if splitPath[1] == "_count" {
+			dbe.countData(w, r, tableName)
+		} else if len(splitPath) == 3 && splitPath[2] == "_history" {
+			dbe.showHistory(w, r, tableName, splitPath[1])
 		} else {
 			dbe.showDataById(w, r, tableName, splitPath[1])
 		}
 	case "PUT":
 		dbe.createData(w, r, tableName)
 	case "POST":
-		dbe.updateData(w, r, tableName, splitPath[1])
+		if tableName == "_batch" {
+			dbe.runBatch(w, r)
+		} else if tableName == "_reload" {
+			dbe.reloadSchema(w, r)
+		} else if idFromPath(splitPath) == "_import" {
+			dbe.importData(w, r, tableName)
+		} else if len(splitPath) == 3 && splitPath[2] == "_restore" {
+			dbe.restoreData(w, r, tableName, splitPath[1])
+		} else {
+			dbe.updateData(w, r, tableName, idFromPath(splitPath))
+		}
 	case "DELETE":
-		dbe.deleteData(w, r, tableName, splitPath[1])
+		dbe.deleteData(w, r, tableName, idFromPath(splitPath))
 	}
 }
 
-func (dbe *DBExplorer) showTable(w http.ResponseWriter) {
-	var tableNames []string
-	for tableName := range dbe.tables {
-		tableNames = append(tableNames, tableName)
+func idFromPath(splitPath []string) string {
+	if len(splitPath) < 2 {
+		return ""
 	}
+	return splitPath[1]
+}
+
+func (dbe *DBExplorer) showTable(w http.ResponseWriter, r *http.Request) {
+	acc := accessFrom(r.Context())
+	tableNames := []string{}
+	for tableName := range dbe.schema() {
+		if acc.canRead(tableName) == nil {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	sort.Strings(tableNames)
 
 	var response = map[string]interface{}{
 		"response": map[string]interface{}{
@@ -138,216 +284,346 @@ func (dbe *DBExplorer) sendError(w http.ResponseWriter, message string, code int
 	json.NewEncoder(w).Encode(response)
 }
 
-func (dbe *DBExplorer) showData(w http.ResponseWriter, r *http.Request, tableName string) {
-	limit := 5
-	offset := 0
+// showData lists records, with search set it serves /$table/_search and keeps only records matching ?q=
+func (dbe *DBExplorer) showData(w http.ResponseWriter, r *http.Request, tableName string, search bool) {
+	acc := accessFrom(r.Context())
+	if err := acc.canRead(tableName); err != nil {
+		dbe.sendError(w, err.Error(), 403)
+		return
+	}
+
+	table, ok := dbe.table(tableName)
+	if !ok {
+		dbe.sendError(w, "unknown table", 404)
+		return
+	}
 
-	if l := r.URL.Query().Get("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil {
-			limit = parsed
-		}
+	lq, err := parseListQuery(table, r.URL.Query())
+	if err != nil {
+		dbe.sendError(w, err.Error(), 400)
+		return
 	}
-	if o := r.URL.Query().Get("offset"); o != "" {
-		if parsed, err := strconv.Atoi(o); err == nil {
-			offset = parsed
+	if err := acc.checkColumns(tableName, lq.columns()); err != nil {
+		dbe.sendError(w, err.Error(), 403)
+		return
+	}
+	if search {
+		lq.Search, err = newTextSearch(dbe.dialect, table, acc, r.URL.Query().Get("q"))
+		if err != nil {
+			dbe.sendError(w, err.Error(), 400)
+			return
 		}
 	}
 
-	query := fmt.Sprintf("SELECT * FROM `%s` LIMIT ? OFFSET ?", tableName)
-	rows, err := dbe.db.Query(query, limit, offset)
+	query, args := lq.sql(dbe.dialect, tableName)
+	rows, err := dbe.conn(r.Context()).Query(dbe.dialect.Rebind(query), args...)
 	if err != nil {
-		dbe.sendError(w, err.Error(), 500)
+		dbe.sendFailure(w, err)
 		return
 	}
 
 	records, err := dbe.readRows(rows)
 	if err != nil {
-		dbe.sendError(w, err.Error(), 500)
+		dbe.sendFailure(w, err)
+		return
+	}
+
+	if err := dbe.expandRecords(dbe.conn(r.Context()), acc, lq.Expand, records, withDeleted(r)); err != nil {
+		dbe.sendFailure(w, err)
 		return
 	}
 
+	// the cursor holds the keyset values, so there is none when one of them is hidden
+	var nextCursor string
+	if len(records) > 0 && len(records) == lq.Limit && lq.cursorable(table) &&
+		acc.checkColumns(tableName, lq.keysetColumns()) == nil {
+		nextCursor = lq.encodeCursor(table, records[len(records)-1])
+	}
+	for _, record := range records {
+		var matched []string
+		if lq.Search != nil {
+			matched = lq.Search.matched(record)
+		}
+		for _, extra := range lq.missingFields() {
+			delete(record, extra)
+		}
+		if lq.Search != nil {
+			record["_matched"] = matched
+		}
+	}
+	acc.maskRecords(tableName, records)
+
+	result := map[string]interface{}{
+		"records": records,
+	}
+	if nextCursor != "" {
+		result["next_cursor"] = nextCursor
+	}
 	var response = map[string]interface{}{
-		"response": map[string]interface{}{
-			"records": records,
-		},
+		"response": result,
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
 func (dbe *DBExplorer) showDataById(w http.ResponseWriter, r *http.Request, tableName, idStr string) {
-	table := dbe.tables[tableName]
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		dbe.sendError(w, "Invalid id", 400)
+	acc := accessFrom(r.Context())
+	if err := acc.canRead(tableName); err != nil {
+		dbe.sendError(w, err.Error(), 403)
 		return
 	}
 
-	query := fmt.Sprintf("SELECT * FROM `%s` WHERE `%s` = ?", tableName, table.PrimaryKey)
-	rows, err := dbe.db.Query(query, id)
+	table, ok := dbe.table(tableName)
+	if !ok {
+		dbe.sendError(w, "unknown table", 404)
+		return
+	}
+
+	key, err := parseKey(table, idStr, r.URL.Query())
+	if err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+	expand, err := parseExpand(table, r.URL.Query().Get("expand"))
 	if err != nil {
-		dbe.sendError(w, err.Error(), 500)
+		dbe.sendError(w, err.Error(), 400)
+		return
+	}
+	if err := acc.checkColumns(tableName, expandColumns(expand)); err != nil {
+		dbe.sendError(w, err.Error(), 403)
 		return
 	}
 
-	records, err := dbe.readRows(rows)
+	record, err := dbe.fetchRecord(dbe.conn(r.Context()), table, key)
 	if err != nil {
-		dbe.sendError(w, err.Error(), 500)
+		dbe.sendFailure(w, err)
 		return
 	}
 
-	if len(records) == 0 {
+	if record == nil || table.isDeleted(record) && !withDeleted(r) {
 		dbe.sendError(w, "record not found", 404)
 		return
 	}
 
+	w.Header().Set("ETag", recordETag(record))
+	if err := dbe.expandRecords(dbe.conn(r.Context()), acc, expand, []map[string]interface{}{record}, withDeleted(r)); err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+	acc.maskRecords(tableName, []map[string]interface{}{record})
+
 	var response = map[string]interface{}{
 		"response": map[string]interface{}{
-			"record": records[0],
+			"record": record,
 		},
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
 func (dbe *DBExplorer) createData(w http.ResponseWriter, r *http.Request, tableName string) {
-	table := dbe.tables[tableName]
+	acc := accessFrom(r.Context())
+	if err := acc.canWrite(tableName); err != nil {
+		dbe.sendError(w, err.Error(), 403)
+		return
+	}
 
-	var data map[string]interface{}
-	json.NewDecoder(r.Body).Decode(&data)
+	table, ok := dbe.table(tableName)
+	if !ok {
+		dbe.sendError(w, "unknown table", 404)
+		return
+	}
+
+	var body interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		dbe.sendError(w, "invalid json", 400)
+		return
+	}
+
+	switch data := body.(type) {
+	case map[string]interface{}:
+		if err := acc.checkWrite(tableName, data); err != nil {
+			dbe.sendError(w, err.Error(), 403)
+			return
+		}
+
+		var key recordKey
+		err := dbe.inTx(r.Context(), func(tx queryer) (err error) {
+			key, err = dbe.insertRecord(tx, acc, table, data)
+			return err
+		})
+		if err != nil {
+			dbe.sendFailure(w, err)
+			return
+		}
 
-	var keys []string
-	var values []interface{}
+		var response = map[string]interface{}{
+			"response": key.fields(table),
+		}
+		json.NewEncoder(w).Encode(response)
 
-	for _, col := range table.Columns {
-		if value, exists := data[col.Name]; exists {
-			if value == nil && !col.IsNullable {
-				dbe.sendError(w, fmt.Sprintf("field %s have invalid type", col.Name), 400)
+	case []interface{}:
+		for _, record := range data {
+			record, _ := record.(map[string]interface{})
+			if err := acc.checkWrite(tableName, record); err != nil {
+				dbe.sendError(w, err.Error(), 403)
 				return
 			}
+		}
 
-			keys = append(keys, "`"+col.Name+"`")
-			values = append(values, value)
-		} else if !col.IsNullable {
-			keys = append(keys, "`"+col.Name+"`")
-			if strings.Contains(col.Type, "int") {
-				values = append(values, 0)
-			} else {
-				values = append(values, "")
-			}
+		ids, err := dbe.insertRecords(r.Context(), acc, table, data)
+		if err != nil {
+			dbe.sendFailure(w, err)
+			return
+		}
+
+		var response = map[string]interface{}{
+			"response": map[string]interface{}{
+				"ids": ids,
+			},
 		}
+		json.NewEncoder(w).Encode(response)
+
+	default:
+		dbe.sendError(w, "invalid json", 400)
 	}
+}
 
-	placeholders := strings.Repeat("?,", len(values))
-	placeholders = placeholders[:len(placeholders)-1]
-	query := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s)",
-		tableName, strings.Join(keys, ","), placeholders)
+func (dbe *DBExplorer) updateData(w http.ResponseWriter, r *http.Request, tableName, idStr string) {
+	acc := accessFrom(r.Context())
+	if err := acc.canWrite(tableName); err != nil {
+		dbe.sendError(w, err.Error(), 403)
+		return
+	}
 
-	result, err := dbe.db.Exec(query, values...)
-	if err != nil {
-		dbe.sendError(w, err.Error(), 500)
+	table, ok := dbe.table(tableName)
+	if !ok {
+		dbe.sendError(w, "unknown table", 404)
 		return
 	}
-	lastID, _ := result.LastInsertId()
 
-	var response = map[string]interface{}{
-		"response": map[string]interface{}{
-			table.PrimaryKey: int(lastID),
-		},
+	if idStr == "" {
+		dbe.updateBulk(w, r, table)
+		return
 	}
-	json.NewEncoder(w).Encode(response)
-}
 
-func (dbe *DBExplorer) updateData(w http.ResponseWriter, r *http.Request, tableName, idStr string) {
-	table := dbe.tables[tableName]
-	id, err := strconv.Atoi(idStr)
+	key, err := parseKey(table, idStr, r.URL.Query())
 	if err != nil {
-		dbe.sendError(w, "invalid id", 400)
+		dbe.sendFailure(w, err)
 		return
 	}
 
 	var data map[string]interface{}
-	json.NewDecoder(r.Body).Decode(&data)
-
-	var setSplits []string
-	var values []interface{}
-
-	for fieldName, value := range data {
-		var column *Column
-		for _, col := range table.Columns {
-			if col.Name == fieldName {
-				column = &col
-				break
-			}
-		}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		dbe.sendError(w, "invalid json", 400)
+		return
+	}
+	if err := acc.checkWrite(tableName, data); err != nil {
+		dbe.sendError(w, err.Error(), 403)
+		return
+	}
 
-		if column == nil {
-			continue
+	var affected int
+	var etag string
+	err = dbe.inTx(r.Context(), func(tx queryer) (err error) {
+		if err := dbe.checkIfMatch(tx, table, key, r.Header.Get("If-Match")); err != nil {
+			return err
 		}
-
-		if column.IsPrimaryKey {
-			dbe.sendError(w, fmt.Sprintf("field %s have invalid type", fieldName), 400)
-			return
+		affected, err = dbe.updateRecord(tx, acc, table, key, data, withDeleted(r))
+		if err != nil {
+			return err
 		}
-
-		if value == nil && !column.IsNullable {
-			dbe.sendError(w, fmt.Sprintf("field %s have invalid type", fieldName), 400)
-			return
+		record, err := dbe.fetchRecord(tx, table, key)
+		if record != nil {
+			etag = recordETag(record)
 		}
+		return err
+	})
+	if err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
 
-		setSplits = append(setSplits, fmt.Sprintf("`%s` = ?", fieldName))
-		values = append(values, value)
+	var response = map[string]interface{}{
+		"response": map[string]interface{}{
+			"updated": affected,
+		},
 	}
+	json.NewEncoder(w).Encode(response)
+}
 
-	if len(setSplits) == 0 {
-		dbe.sendError(w, "nothing to update", 400)
+func (dbe *DBExplorer) updateBulk(w http.ResponseWriter, r *http.Request, table Table) {
+	var data []map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		dbe.sendError(w, "invalid json", 400)
 		return
 	}
+	acc := accessFrom(r.Context())
+	for _, record := range data {
+		if err := acc.checkWrite(table.Name, record); err != nil {
+			dbe.sendError(w, err.Error(), 403)
+			return
+		}
+	}
 
-	values = append(values, id)
-	query := fmt.Sprintf("UPDATE `%s` SET %s WHERE `%s` = ?",
-		tableName, strings.Join(setSplits, ", "), table.PrimaryKey)
-
-	result, err := dbe.db.Exec(query, values...)
+	affected, err := dbe.updateRecords(r.Context(), acc, table, data, withDeleted(r))
 	if err != nil {
-		dbe.sendError(w, err.Error(), 500)
+		dbe.sendFailure(w, err)
 		return
 	}
 
-	affected, _ := result.RowsAffected()
-
 	var response = map[string]interface{}{
 		"response": map[string]interface{}{
-			"updated": int(affected),
+			"updated": affected,
 		},
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
 func (dbe *DBExplorer) deleteData(w http.ResponseWriter, r *http.Request, tableName, idStr string) {
-	table := dbe.tables[tableName]
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		dbe.sendError(w, "invalid id", 400)
+	acc := accessFrom(r.Context())
+	if err := acc.canWrite(tableName); err != nil {
+		dbe.sendError(w, err.Error(), 403)
 		return
 	}
 
-	query := fmt.Sprintf("DELETE FROM `%s` WHERE `%s` = ?", tableName, table.PrimaryKey)
-	result, err := dbe.db.Exec(query, id)
+	table, ok := dbe.table(tableName)
+	if !ok {
+		dbe.sendError(w, "unknown table", 404)
+		return
+	}
+
+	key, err := parseKey(table, idStr, r.URL.Query())
 	if err != nil {
-		dbe.sendError(w, err.Error(), 500)
+		dbe.sendFailure(w, err)
 		return
 	}
 
-	affected, _ := result.RowsAffected()
+	var affected int
+	err = dbe.inTx(r.Context(), func(tx queryer) (err error) {
+		if err := dbe.checkIfMatch(tx, table, key, r.Header.Get("If-Match")); err != nil {
+			return err
+		}
+		affected, err = dbe.deleteRecord(tx, acc, table, key)
+		return err
+	})
+	if err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
 
 	var response = map[string]interface{}{
 		"response": map[string]interface{}{
-			"deleted": int(affected),
+			"deleted": affected,
 		},
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
 func (dbe *DBExplorer) readRows(rows *sql.Rows) ([]map[string]interface{}, error) {
+	defer rows.Close()
+
 	columns, err := rows.Columns()
 	if err != nil {
 		return nil, err
@@ -356,34 +632,42 @@ func (dbe *DBExplorer) readRows(rows *sql.Rows) ([]map[string]interface{}, error
 	var result []map[string]interface{}
 
 	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		pointers := make([]interface{}, len(columns))
-		for i := range values {
-			pointers[i] = &values[i]
-		}
-
-		err := rows.Scan(pointers...)
+		data, err := scanRow(rows, columns)
 		if err != nil {
 			return nil, err
 		}
+		result = append(result, data)
+	}
 
-		data := make(map[string]interface{})
-		for i, colName := range columns {
-			val := values[i]
-			if val == nil {
-				data[colName] = nil
+	return result, rows.Err()
+}
+
+func scanRow(rows *sql.Rows, columns []string) (map[string]interface{}, error) {
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	err := rows.Scan(pointers...)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]interface{})
+	for i, colName := range columns {
+		val := values[i]
+		if val == nil {
+			data[colName] = nil
+		} else {
+			if bytes, ok := val.([]byte); ok {
+				data[colName] = string(bytes)
+			} else if intVal, ok := val.(int64); ok {
+				data[colName] = int(intVal)
 			} else {
-				if bytes, ok := val.([]byte); ok {
-					data[colName] = string(bytes)
-				} else if intVal, ok := val.(int64); ok {
-					data[colName] = int(intVal)
-				} else {
-					data[colName] = val
-				}
+				data[colName] = val
 			}
 		}
-		result = append(result, data)
 	}
-
-	return result, nil
+	return data, nil
 }
@@ -0,0 +1,1967 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type CR map[string]interface{}
+
+type Case struct {
+	Method string
+	Path   string
+	Query  string
+	Token  string
+	Header map[string]string
+	Status int
+	Result interface{}
+	Body   interface{}
+}
+
+var testSchema = []string{
+	`CREATE TABLE items (
+		id INTEGER PRIMARY KEY,
+		title VARCHAR(255) NOT NULL,
+		description TEXT NOT NULL,
+		updated VARCHAR(255) DEFAULT NULL
+	)`,
+	`INSERT INTO items (id, title, description, updated) VALUES
+		(1, 'database/sql', 'Рассказать про базы данных', 'rvasily'),
+		(2, 'memcache', 'Рассказать про мемкеш с примером использования', NULL)`,
+	`CREATE TABLE users (
+		user_id INTEGER PRIMARY KEY,
+		login VARCHAR(255) NOT NULL,
+		password VARCHAR(255) NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		info TEXT NOT NULL,
+		updated VARCHAR(255) DEFAULT NULL
+	)`,
+	`INSERT INTO users (user_id, login, password, email, info, updated) VALUES
+		(1, 'rvasily', 'love', 'rvasily@example.com', 'none', NULL)`,
+}
+
+func newTestDB(t *testing.T, schema ...string) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "explorer.db"))
+	if err != nil {
+		t.Fatalf("cant open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, q := range schema {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("cant prepare db: %v\n%s", err, q)
+		}
+	}
+	return db
+}
+
+func newTestServer(t *testing.T, db *sql.DB) *httptest.Server {
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatalf("cant create explorer: %v", err)
+	}
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func runCases(t *testing.T, ts *httptest.Server, cases []Case) {
+	client := &http.Client{}
+	for idx, item := range cases {
+		var req *http.Request
+		url := ts.URL + item.Path
+		if item.Query != "" {
+			url += "?" + item.Query
+		}
+
+		if item.Body != nil {
+			data, _ := json.Marshal(item.Body)
+			req, _ = http.NewRequest(item.Method, url, bytes.NewReader(data))
+		} else {
+			req, _ = http.NewRequest(item.Method, url, nil)
+		}
+		if item.Method == "" {
+			req.Method = http.MethodGet
+		}
+		if item.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+item.Token)
+		}
+		for name, value := range item.Header {
+			req.Header.Set(name, value)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("[%d] request error: %v", idx, err)
+		}
+
+		var result interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+
+		if resp.StatusCode != item.Status {
+			t.Errorf("[%d] expected http status %v, got %v: %v", idx, item.Status, resp.StatusCode, result)
+			continue
+		}
+
+		var expected interface{}
+		data, _ := json.Marshal(item.Result)
+		json.Unmarshal(data, &expected)
+
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("[%d] results not match\nGot : %#v\nWant: %#v", idx, result, expected)
+		}
+	}
+}
+
+func TestDialectDetection(t *testing.T) {
+	db := newTestDB(t)
+	dialect, err := detectDialect(db)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dialect.Name() != "sqlite" {
+		t.Errorf("expected sqlite dialect, got %s", dialect.Name())
+	}
+
+	rebinds := map[string]string{
+		"a = ? AND b IN (?, ?)":                       "a = $1 AND b IN ($2, $3)",
+		`SELECT "wh?t", 'why?' FROM t WHERE a = ?`:    `SELECT "wh?t", 'why?' FROM t WHERE a = $1`,
+		`SELECT 'it''s ?', "a""?" FROM t WHERE b = ?`: `SELECT 'it''s ?', "a""?" FROM t WHERE b = $1`,
+	}
+	for query, expected := range rebinds {
+		if got := (postgresDialect{}).Rebind(query); got != expected {
+			t.Errorf("bad rebind of %s: %s", query, got)
+		}
+	}
+	types := map[string]columnType{
+		"double precision":            {Base: "double"},
+		"character varying":           {Base: "varchar"},
+		"character varying(32)":       {Base: "varchar", Size: 32},
+		"timestamp without time zone": {Base: "timestamp"},
+		"int(10) unsigned":            {Base: "int", Size: 10, Unsigned: true},
+		"decimal(10,2)":               {Base: "decimal", Size: 10, Scale: 2},
+	}
+	for raw, expected := range types {
+		if got := parseColumnType(raw); !reflect.DeepEqual(got, expected) {
+			t.Errorf("bad type %s: %+v", raw, got)
+		}
+	}
+	if got := (mysqlDialect{}).Quote("we`ird"); got != "`we``ird`" {
+		t.Errorf("bad quote: %s", got)
+	}
+}
+
+func TestCRUD(t *testing.T) {
+	ts := newTestServer(t, newTestDB(t, testSchema...))
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"tables": []string{"items", "users"}}},
+		},
+		{
+			Path:   "/unknown_table",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown table"},
+		},
+		{
+			Path:   "/items",
+			Query:  "limit=1",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{
+				"records": []CR{
+					{"id": 1, "title": "database/sql", "description": "Рассказать про базы данных", "updated": "rvasily"},
+				},
+				"next_cursor": "eyJvIjoiaWQiLCJ2IjpbMV19",
+			}},
+		},
+		{
+			Path:   "/items/2",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{
+				"id": 2, "title": "memcache", "description": "Рассказать про мемкеш с примером использования", "updated": nil,
+			}}},
+		},
+		{
+			Path:   "/items/100500",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "record not found"},
+		},
+		{
+			Method: http.MethodPut,
+			Path:   "/items/",
+			Body:   CR{"title": "db_crud", "description": ""},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"id": 3}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/items/3",
+			Body:   CR{"description": "Написать программу db_crud", "updated": nil},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/items/3",
+			Body:   CR{"id": 4},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "field id have invalid type", "fields": CR{"id": "field id have invalid type"}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/items/3",
+			Body:   CR{"title": 42, "description": nil},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "field description have invalid type", "fields": CR{
+				"description": "field description have invalid type",
+				"title":       "field title have invalid type",
+			}},
+		},
+		{
+			Method: http.MethodDelete,
+			Path:   "/items/3",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"deleted": 1}},
+		},
+		{
+			Method: http.MethodDelete,
+			Path:   "/items/3",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"deleted": 0}},
+		},
+	})
+}
+
+func TestListQuery(t *testing.T) {
+	ts := newTestServer(t, newTestDB(t, testSchema...))
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/items",
+			Query:  "fields=id,title&order=-id",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{
+				{"id": 2, "title": "memcache"},
+				{"id": 1, "title": "database/sql"},
+			}}},
+		},
+		{
+			Path:   "/items",
+			Query:  "fields=id&where[updated][null]=1",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"id": 2}}}},
+		},
+		{
+			Path:   "/items",
+			Query:  "fields=title&where[id][gt]=1",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"title": "memcache"}}}},
+		},
+		{
+			Path:   "/items",
+			Query:  "where[title][like]=data%25",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{
+				{"id": 1, "title": "database/sql", "description": "Рассказать про базы данных", "updated": "rvasily"},
+			}}},
+		},
+		{
+			Path:   "/items",
+			Query:  "where[secret][eq]=1",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "unknown column secret"},
+		},
+		{
+			Path:   "/items",
+			Query:  "where[id][regexp]=1",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "unknown operator regexp"},
+		},
+		{
+			Path:   "/items",
+			Query:  "order=-secret",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "unknown column secret"},
+		},
+	})
+}
+
+func TestParseListQuery(t *testing.T) {
+	table := Table{
+		Name: "items",
+		Columns: []Column{
+			{Name: "id", Type: "int(11)", IsPrimaryKey: true},
+			{Name: "title", Type: "varchar(255)"},
+			{Name: "age", Type: "int(11)"},
+			{Name: "updated", Type: "varchar(255)", IsNullable: true},
+		},
+		PrimaryKey: []string{"id"},
+	}
+
+	cases := []struct {
+		Query string
+		SQL   string
+		Args  []interface{}
+		Error string
+	}{
+		{
+			Query: "",
+			SQL:   "SELECT * FROM `items` ORDER BY `id` ASC LIMIT ? OFFSET ?",
+			Args:  []interface{}{5, 0},
+		},
+		{
+			Query: "where[age][gt]=30&order=-updated&fields=id,title&limit=10&offset=20",
+			SQL:   "SELECT `id`, `title`, `updated` FROM `items` WHERE `age` > ? ORDER BY `updated` DESC, `id` ASC LIMIT ? OFFSET ?",
+			Args:  []interface{}{"30", 10, 20},
+		},
+		{
+			Query: "where[title]=x' OR 1=1&where[id][in]=1,2&where[updated][null]=false",
+			SQL:   "SELECT * FROM `items` WHERE `id` IN (?,?) AND `title` = ? AND `updated` IS NOT NULL ORDER BY `id` ASC LIMIT ? OFFSET ?",
+			Args:  []interface{}{"1", "2", "x' OR 1=1", 5, 0},
+		},
+		{
+			Query: "where[title][like]=a%25&where[age][lte]=1&where[age][ne]=0",
+			SQL:   "SELECT * FROM `items` WHERE `age` <= ? AND `age` <> ? AND `title` LIKE ? ORDER BY `id` ASC LIMIT ? OFFSET ?",
+			Args:  []interface{}{"1", "0", "a%", 5, 0},
+		},
+		{Query: "fields=id,secret", Error: "unknown column secret"},
+		{Query: "order=id,-secret", Error: "unknown column secret"},
+		{Query: "where[secret]=1", Error: "unknown column secret"},
+		{Query: "where[id][between]=1", Error: "unknown operator between"},
+		{Query: "where[id][eq][eq]=1", Error: "invalid filter where[id][eq][eq]"},
+		{Query: "where[updated][null]=maybe", Error: "invalid value for where[updated][null]"},
+	}
+
+	for _, item := range cases {
+		params, err := url.ParseQuery(item.Query)
+		if err != nil {
+			t.Fatalf("bad query %s: %v", item.Query, err)
+		}
+		lq, err := parseListQuery(table, params)
+		if item.Error != "" {
+			if err == nil || err.Error() != item.Error {
+				t.Errorf("[%s] expected error %q, got %v", item.Query, item.Error, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %v", item.Query, err)
+			continue
+		}
+		query, args := lq.sql(mysqlDialect{}, table.Name)
+		if query != item.SQL || !reflect.DeepEqual(args, item.Args) {
+			t.Errorf("[%s] unexpected sql\nGot : %s %v\nWant: %s %v", item.Query, query, args, item.SQL, item.Args)
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema := append(append([]string{}, testSchema...),
+		`CREATE TABLE comments (
+			id INTEGER PRIMARY KEY,
+			item_id INTEGER NOT NULL REFERENCES items(id),
+			body TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX comments_item ON comments (item_id)`,
+		`CREATE TABLE tags (
+			name VARCHAR(32) NOT NULL,
+			item_id INTEGER NOT NULL,
+			user_id INTEGER DEFAULT NULL,
+			UNIQUE (item_id, name),
+			FOREIGN KEY (item_id) REFERENCES items(id),
+			FOREIGN KEY (user_id) REFERENCES users(user_id)
+		)`,
+	)
+	ts := newTestServer(t, newTestDB(t, schema...))
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"tables": []string{"comments", "items", "tags", "users"}}},
+		},
+		{
+			Path:   "/comments/_schema",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"table": Table{
+				Name: "comments",
+				Columns: []Column{
+					{Name: "id", Type: "INTEGER", IsNullable: true, IsPrimaryKey: true, Extra: "auto_increment"},
+					{Name: "item_id", Type: "INTEGER"},
+					{Name: "body", Type: "TEXT", Default: &[]string{"''"}[0]},
+				},
+				PrimaryKey:  []string{"id"},
+				Indexes:     []Index{{Name: "comments_item", Columns: []string{"item_id"}, Type: "c"}},
+				ForeignKeys: []ForeignKey{{Name: "fk_0", Column: "item_id", RefTable: "items", RefColumn: "id"}},
+			}}},
+		},
+		{
+			Path:   "/tags/_schema",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"table": Table{
+				Name: "tags",
+				Columns: []Column{
+					{Name: "name", Type: "VARCHAR(32)"},
+					{Name: "item_id", Type: "INTEGER"},
+					{Name: "user_id", Type: "INTEGER", IsNullable: true, Default: &[]string{"NULL"}[0]},
+				},
+				Indexes: []Index{
+					{Name: "sqlite_autoindex_tags_1", Columns: []string{"item_id", "name"}, Unique: true, Type: "u"},
+				},
+				ForeignKeys: []ForeignKey{
+					{Name: "fk_0", Column: "user_id", RefTable: "users", RefColumn: "user_id"},
+					{Name: "fk_1", Column: "item_id", RefTable: "items", RefColumn: "id"},
+				},
+			}}},
+		},
+		{
+			Path:   "/nothing/_schema",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown table"},
+		},
+	})
+}
+
+func TestValidateValue(t *testing.T) {
+	cases := []struct {
+		Type     string
+		Null     bool
+		MaxBytes int
+		Value    interface{}
+		Result   interface{}
+		Error    string
+	}{
+		{Type: "int(11)", Value: float64(42), Result: int64(42)},
+		{Type: "int(11)", Value: 4.2, Error: "field f have invalid type"},
+		{Type: "int(11)", Value: "42", Error: "field f have invalid type"},
+		{Type: "int(11)", Value: nil, Error: "field f have invalid type"},
+		{Type: "int(11)", Null: true, Value: nil, Result: nil},
+		{Type: "int(10) unsigned", Value: float64(-1), Error: "field f must be unsigned"},
+		{Type: "tinyint(4)", Value: float64(-128), Result: int64(-128)},
+		{Type: "tinyint(4)", Value: float64(128), Error: "field f is out of range"},
+		{Type: "tinyint(3) unsigned", Value: float64(255), Result: int64(255)},
+		{Type: "tinyint(3) unsigned", Value: float64(256), Error: "field f is out of range"},
+		{Type: "smallint(6)", Value: float64(-32769), Error: "field f is out of range"},
+		{Type: "smallint(5) unsigned", Value: float64(65535), Result: int64(65535)},
+		{Type: "mediumint(9)", Value: float64(8388607), Result: int64(8388607)},
+		{Type: "mediumint(8) unsigned", Value: float64(16777216), Error: "field f is out of range"},
+		{Type: "int(11)", Value: float64(2147483648), Error: "field f is out of range"},
+		{Type: "int(10) unsigned", Value: float64(4294967295), Result: int64(4294967295)},
+		{Type: "bigint(20)", Value: float64(-1 << 63), Result: int64(-1 << 63)},
+		{Type: "bigint(20)", Value: float64(1 << 63), Error: "field f is out of range"},
+		{Type: "bigint(20) unsigned", Value: float64(1 << 63), Result: uint64(1 << 63)},
+		{Type: "bigint(20) unsigned", Value: float64(1 << 64), Error: "field f is out of range"},
+		{Type: "decimal(5,2)", Value: 999.99, Result: 999.99},
+		{Type: "decimal(5,2)", Value: float64(1000), Error: "field f is out of range"},
+		{Type: "float unsigned", Value: -0.5, Error: "field f must be unsigned"},
+		{Type: "varchar(3)", Value: "абв", Result: "абв"},
+		{Type: "varchar(3)", Value: "abcd", Error: "field f is longer than 3"},
+		{Type: "tinytext", MaxBytes: 255, Value: strings.Repeat("a", 255), Result: strings.Repeat("a", 255)},
+		// 128 cyrillic letters are 256 bytes
+		{Type: "tinytext", MaxBytes: 255, Value: strings.Repeat("я", 128), Error: "field f is longer than 255 bytes"},
+		// text of postgres and sqlite has no limit
+		{Type: "text", Value: strings.Repeat("a", 70000), Result: strings.Repeat("a", 70000)},
+		{Type: "text", Value: 1.0, Error: "field f have invalid type"},
+		{Type: "enum('a','b')", Value: "b", Result: "b"},
+		{Type: "enum('a','b')", Value: "c", Error: "field f must be one of a, b"},
+		{Type: "datetime", Value: "2020-01-02T03:04:05Z", Result: "2020-01-02 03:04:05"},
+		{Type: "datetime", Value: "2024-01-01T10:00:00+03:00", Result: "2024-01-01 07:00:00"},
+		{Type: "date", Value: "2024-01-01T01:00:00+03:00", Result: "2023-12-31"},
+		{Type: "date", Value: "2020-01-02 03:04:05", Result: "2020-01-02"},
+		{Type: "datetime", Value: "yesterday", Error: "field f is not a valid datetime"},
+	}
+
+	for idx, item := range cases {
+		result, err := validateValue(Column{Name: "f", Type: item.Type, IsNullable: item.Null, MaxBytes: item.MaxBytes}, item.Value)
+		if item.Error != "" {
+			if err == nil || err.Error() != item.Error {
+				t.Errorf("[%d] %s: expected error %q, got %v", idx, item.Type, item.Error, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(result, item.Result) {
+			t.Errorf("[%d] %s: expected %#v, got %#v, %v", idx, item.Type, item.Result, result, err)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ts := newTestServer(t, newTestDB(t, testSchema...))
+
+	runCases(t, ts, []Case{
+		{
+			Method: http.MethodPut,
+			Path:   "/items",
+			Body:   []CR{{"title": "a", "description": ""}, {"title": "b", "description": ""}},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"ids": []int{3, 4}}},
+		},
+		{
+			Method: http.MethodPut,
+			Path:   "/items",
+			Body:   []CR{{"title": "c", "description": ""}, {"title": 1}},
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error":  "record 1: field title have invalid type",
+				"fields": CR{"title": "field title have invalid type"},
+				"index":  1,
+			},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/items",
+			Body:   []CR{{"id": 3, "title": "aa"}, {"id": 4, "title": "bb"}},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"updated": 2}},
+		},
+		{
+			Path:   "/items",
+			Query:  "fields=id,title&where[id][gte]=3",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"id": 3, "title": "aa"}, {"id": 4, "title": "bb"}}}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/_batch",
+			Body: CR{"operations": []CR{
+				{"op": "create", "table": "users", "data": CR{"login": "new", "password": "", "email": "", "info": ""}},
+				{"op": "update", "table": "items", "id": 3, "data": CR{"updated": "new"}},
+				{"op": "delete", "table": "items", "id": "4"},
+			}},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"results": []CR{
+				{"op": "create", "table": "users", "user_id": 2},
+				{"op": "update", "table": "items", "updated": 1},
+				{"op": "delete", "table": "items", "deleted": 1},
+			}}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/_batch",
+			Body: CR{"operations": []CR{
+				{"op": "delete", "table": "items", "id": 3},
+				{"op": "update", "table": "nothing", "id": 1, "data": CR{"title": "x"}},
+			}},
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "operation 1: unknown table",
+				"results": []CR{
+					{"op": "delete", "table": "items", "rolled_back": true},
+					{"op": "update", "table": "nothing", "error": "unknown table"},
+				},
+			},
+		},
+		{
+			Path:   "/items",
+			Query:  "fields=id&where[id][gte]=3",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"id": 3}}}},
+		},
+	})
+}
+
+func TestCompositeKey(t *testing.T) {
+	ts := newTestServer(t, newTestDB(t,
+		`CREATE TABLE roles (
+			user_id INTEGER NOT NULL,
+			role VARCHAR(16) NOT NULL,
+			note TEXT,
+			PRIMARY KEY (user_id, role)
+		)`,
+		`CREATE TABLE tokens (
+			token VARCHAR(36) NOT NULL PRIMARY KEY,
+			owner VARCHAR(255) NOT NULL
+		)`,
+		`CREATE TABLE grants (
+			role VARCHAR(16) NOT NULL,
+			user_id INTEGER NOT NULL,
+			PRIMARY KEY (user_id, role)
+		)`,
+		`CREATE TABLE codes (
+			code VARCHAR(8) NOT NULL DEFAULT 'none' PRIMARY KEY,
+			name TEXT NOT NULL
+		)`,
+		`CREATE TABLE events (
+			name VARCHAR(32) NOT NULL,
+			count INTEGER NOT NULL
+		)`,
+	))
+
+	runCases(t, ts, []Case{
+		{
+			Method: http.MethodPut,
+			Path:   "/roles",
+			Body:   CR{"user_id": 1, "role": "admin", "note": "first"},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"user_id": 1, "role": "admin"}},
+		},
+		{
+			Path:   "/roles/1,admin",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{"user_id": 1, "role": "admin", "note": "first"}}},
+		},
+		{
+			Path:   "/roles/_key",
+			Query:  "user_id=1&role=admin",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{"user_id": 1, "role": "admin", "note": "first"}}},
+		},
+		{
+			Path:   "/roles/admin,1",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "invalid id"},
+		},
+		{
+			Path:   "/roles/1",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "invalid id"},
+		},
+		{
+			Path:   "/roles/_key",
+			Query:  "user_id=1",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "missing key field role"},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/roles/1,admin",
+			Body:   CR{"note": "changed"},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		{
+			Method: http.MethodPut,
+			Path:   "/tokens",
+			Body:   []CR{{"token": "3f1c9a4e-0000-4000-8000-000000000001", "owner": "a"}},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"ids": []string{"3f1c9a4e-0000-4000-8000-000000000001"}}},
+		},
+		{
+			Path:   "/tokens/3f1c9a4e-0000-4000-8000-000000000001",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{"token": "3f1c9a4e-0000-4000-8000-000000000001", "owner": "a"}}},
+		},
+		{
+			Path:   "/tokens/3f1c9a4e-0000-4000-8000-000000000001-too-long-for-the-column",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "invalid id"},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/_batch",
+			Body: CR{"operations": []CR{
+				{"op": "delete", "table": "roles", "id": []interface{}{1, "admin"}},
+				{"op": "delete", "table": "tokens", "id": "3f1c9a4e-0000-4000-8000-000000000001"},
+			}},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"results": []CR{
+				{"op": "delete", "table": "roles", "deleted": 1},
+				{"op": "delete", "table": "tokens", "deleted": 1},
+			}}},
+		},
+		{
+			Path:   "/grants/_schema",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"table": Table{
+				Name: "grants",
+				Columns: []Column{
+					{Name: "role", Type: "VARCHAR(16)", IsPrimaryKey: true},
+					{Name: "user_id", Type: "INTEGER", IsPrimaryKey: true},
+				},
+				PrimaryKey:  []string{"user_id", "role"},
+				Indexes:     []Index{{Name: "sqlite_autoindex_grants_1", Columns: []string{"user_id", "role"}, Unique: true, Type: "pk"}},
+				ForeignKeys: []ForeignKey{},
+			}}},
+		},
+		{
+			Method: http.MethodPut,
+			Path:   "/grants",
+			Body:   CR{"role": "admin", "user_id": 1},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"user_id": 1, "role": "admin"}},
+		},
+		{
+			Path:   "/grants/1,admin",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{"user_id": 1, "role": "admin"}}},
+		},
+		{
+			Path:   "/grants",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"user_id": 1, "role": "admin"}}}},
+		},
+		{
+			// the key is filled by the database and can not be read back, so nothing is written
+			Method: http.MethodPut,
+			Path:   "/codes",
+			Body:   CR{"name": "first"},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "missing key field code"},
+		},
+		{
+			Path:   "/codes",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": nil}},
+		},
+		{
+			// a table without a primary key takes rows, there is just no key to return
+			Method: http.MethodPut,
+			Path:   "/events",
+			Body:   CR{"name": "start", "count": 1},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{}},
+		},
+		{
+			Method: http.MethodPut,
+			Path:   "/events",
+			Body:   []CR{{"name": "stop", "count": 2}},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"ids": []CR{{}}}},
+		},
+		{
+			Path:   "/events",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"name": "start", "count": 1}, {"name": "stop", "count": 2}}}},
+		},
+	})
+}
+
+func TestSchemaReload(t *testing.T) {
+	db := newTestDB(t, testSchema...)
+	handler, err := NewDbExplorer(db, WithReloadInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("cant create explorer: %v", err)
+	}
+	defer handler.(*DBExplorer).Close()
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	if _, err := db.Exec(`CREATE TABLE tags (id INTEGER PRIMARY KEY, name VARCHAR(32) NOT NULL)`); err != nil {
+		t.Fatalf("cant create table: %v", err)
+	}
+	if _, err := db.Exec(`DROP TABLE users`); err != nil {
+		t.Fatalf("cant drop table: %v", err)
+	}
+
+	runCases(t, ts, []Case{
+		{
+			Method: http.MethodPost,
+			Path:   "/_reload",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"tables": []string{"items", "tags"}}},
+		},
+		{
+			Path:   "/users/1",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown table"},
+		},
+		{
+			Method: http.MethodPut,
+			Path:   "/tags",
+			Body:   CR{"name": "go"},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"id": 1}},
+		},
+	})
+
+	if _, err := db.Exec(`ALTER TABLE tags ADD COLUMN color VARCHAR(16)`); err != nil {
+		t.Fatalf("cant alter table: %v", err)
+	}
+	// the background reload picks the new column up without /_reload
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tags, _ := handler.(*DBExplorer).table("tags")
+		if _, ok := tags.findColumn("color"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("schema was not reloaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/tags",
+			Query:  "fields=name,color",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"name": "go", "color": nil}}}},
+		},
+	})
+}
+
+const testPolicy = `{
+	"anonymous": "guest",
+	"tokens": {"admin-token": "admin", "editor-token": "editor"},
+	"roles": {
+		"admin": {"admin": true, "tables": {"*": {"read": true, "write": true}}},
+		"editor": {"tables": {
+			"items": {"read": true, "write": true, "read_only": ["updated"]},
+			"users": {"read": true, "hidden": ["password"]}
+		}},
+		"guest": {"tables": {"items": {"read": true}}}
+	}
+}`
+
+func TestPolicy(t *testing.T) {
+	policy, err := LoadPolicy(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatalf("cant load policy: %v", err)
+	}
+	handler, err := NewDbExplorer(newTestDB(t, testSchema...), WithPolicy(policy))
+	if err != nil {
+		t.Fatalf("cant create explorer: %v", err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"tables": []string{"items"}}},
+		},
+		{
+			Path:   "/",
+			Token:  "wrong",
+			Status: http.StatusUnauthorized,
+			Result: CR{"error": "invalid token"},
+		},
+		{
+			Path:   "/",
+			Header: map[string]string{"Authorization": "admin-token"},
+			Status: http.StatusUnauthorized,
+			Result: CR{"error": "invalid authorization scheme"},
+		},
+		{
+			Path:   "/",
+			Header: map[string]string{"Authorization": "Basic YWRtaW46YWRtaW4="},
+			Status: http.StatusUnauthorized,
+			Result: CR{"error": "invalid authorization scheme"},
+		},
+		{
+			Path:   "/",
+			Header: map[string]string{"Authorization": "bearer admin-token"},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"tables": []string{"items", "users"}}},
+		},
+		{
+			Path:   "/users/1",
+			Status: http.StatusForbidden,
+			Result: CR{"error": "read access to users denied"},
+		},
+		{
+			// a table that does not exist looks the same as a denied one
+			Path:   "/secrets/1",
+			Status: http.StatusForbidden,
+			Result: CR{"error": "read access to secrets denied"},
+		},
+		{
+			Method: http.MethodPut,
+			Path:   "/secrets",
+			Token:  "editor-token",
+			Body:   CR{"value": "x"},
+			Status: http.StatusForbidden,
+			Result: CR{"error": "write access to secrets denied"},
+		},
+		{
+			Path:   "/secrets/1",
+			Token:  "admin-token",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown table"},
+		},
+		{
+			Path:   "/users/1",
+			Token:  "editor-token",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{
+				"user_id": 1, "login": "rvasily", "email": "rvasily@example.com", "info": "none", "updated": nil,
+			}}},
+		},
+		{
+			Path:   "/users",
+			Query:  "where[password]=love",
+			Token:  "editor-token",
+			Status: http.StatusForbidden,
+			Result: CR{"error": "column password is hidden"},
+		},
+		{
+			// hidden columns are not searched, otherwise their values could be guessed
+			Path:   "/users/_search",
+			Query:  "q=love&fields=user_id",
+			Token:  "editor-token",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": nil}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/users/1",
+			Token:  "editor-token",
+			Body:   CR{"info": "hacked"},
+			Status: http.StatusForbidden,
+			Result: CR{"error": "write access to users denied"},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/items/1",
+			Token:  "editor-token",
+			Body:   CR{"title": "new", "updated": "me"},
+			Status: http.StatusForbidden,
+			Result: CR{"error": "field updated is read-only"},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/items/1",
+			Token:  "editor-token",
+			Body:   CR{"title": "new"},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		{
+			Method: http.MethodDelete,
+			Path:   "/items/1",
+			Status: http.StatusForbidden,
+			Result: CR{"error": "write access to items denied"},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/_reload",
+			Token:  "editor-token",
+			Status: http.StatusForbidden,
+			Result: CR{"error": "access denied"},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/_reload",
+			Token:  "admin-token",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"tables": []string{"items", "users"}}},
+		},
+	})
+}
+
+func TestPolicySchema(t *testing.T) {
+	policy, err := LoadPolicy(strings.NewReader(`{
+		"tokens": {"viewer-token": "viewer", "masked-token": "masked"},
+		"roles": {
+			"viewer": {"tables": {"accounts": {"read": true, "hidden": ["secret", "manager_id"]}}},
+			"masked": {"tables": {"accounts": {"read": true, "hidden": ["id"]}}}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("cant load policy: %v", err)
+	}
+	handler, err := NewDbExplorer(newTestDB(t,
+		`CREATE TABLE accounts (
+			id INTEGER PRIMARY KEY,
+			login VARCHAR(32) NOT NULL,
+			secret VARCHAR(32) NOT NULL,
+			manager_id INTEGER REFERENCES accounts(id)
+		)`,
+		`CREATE INDEX accounts_login ON accounts (login)`,
+		`CREATE INDEX accounts_login_secret ON accounts (login, secret)`,
+		`INSERT INTO accounts (id, login, secret) VALUES (1, 'a', 'x'), (2, 'b', 'y')`,
+	), WithPolicy(policy))
+	if err != nil {
+		t.Fatalf("cant create explorer: %v", err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/accounts/_schema",
+			Token:  "viewer-token",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"table": Table{
+				Name: "accounts",
+				Columns: []Column{
+					{Name: "id", Type: "INTEGER", IsNullable: true, IsPrimaryKey: true, Extra: "auto_increment"},
+					{Name: "login", Type: "VARCHAR(32)"},
+				},
+				PrimaryKey:  []string{"id"},
+				Indexes:     []Index{{Name: "accounts_login", Columns: []string{"login"}, Type: "c"}},
+				ForeignKeys: []ForeignKey{},
+			}}},
+		},
+		{
+			Path:   "/accounts/_schema",
+			Token:  "masked-token",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"table": Table{
+				Name: "accounts",
+				Columns: []Column{
+					{Name: "login", Type: "VARCHAR(32)"},
+					{Name: "secret", Type: "VARCHAR(32)"},
+					{Name: "manager_id", Type: "INTEGER", IsNullable: true},
+				},
+				PrimaryKey: []string{},
+				Indexes: []Index{
+					{Name: "accounts_login", Columns: []string{"login"}, Type: "c"},
+					{Name: "accounts_login_secret", Columns: []string{"login", "secret"}, Type: "c"},
+				},
+				ForeignKeys: []ForeignKey{},
+			}}},
+		},
+		{
+			Path:   "/accounts",
+			Query:  "limit=1&fields=login",
+			Token:  "viewer-token",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"login": "a"}}, "next_cursor": "eyJvIjoiaWQiLCJ2IjpbMV19"}},
+		},
+		{
+			// the cursor would carry the hidden id
+			Path:   "/accounts",
+			Query:  "limit=1&fields=login",
+			Token:  "masked-token",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"login": "a"}}}},
+		},
+		{
+			Path:   "/accounts",
+			Query:  "limit=1&fields=login&cursor=eyJvIjoiaWQiLCJ2IjpbMV19",
+			Token:  "masked-token",
+			Status: http.StatusForbidden,
+			Result: CR{"error": "column id is hidden"},
+		},
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	ts := newTestServer(t, newTestDB(t, testSchema...))
+
+	resp, err := http.Get(ts.URL + "/items/_export?format=csv&fields=id,title,updated")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	expectedCSV := "id,title,updated\n1,database/sql,rvasily\n2,memcache,\n"
+	if resp.Header.Get("Content-Type") != "text/csv" || string(body) != expectedCSV {
+		t.Errorf("bad csv export\nGot : %q\nWant: %q", body, expectedCSV)
+	}
+
+	resp, err = http.Get(ts.URL + "/users/_export?format=ndjson")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	expectedNDJSON := `{"email":"rvasily@example.com","info":"none","login":"rvasily","password":"love","updated":null,"user_id":1}` + "\n"
+	if string(body) != expectedNDJSON {
+		t.Errorf("bad ndjson export\nGot : %q\nWant: %q", body, expectedNDJSON)
+	}
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/items/_export",
+			Query:  "format=xml",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "unknown format"},
+		},
+	})
+
+	csvBody := "title,description,updated,unknown\n" +
+		"csv one,first,,x\n" +
+		"\"broken,quote\n"
+	resp, err = http.Post(ts.URL+"/items/_import?format=csv", "text/csv", strings.NewReader(csvBody))
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	var result CR
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if report := result["response"].(map[string]interface{}); report["imported"] != float64(1) || len(report["errors"].([]interface{})) != 1 {
+		t.Errorf("bad csv import report: %v", result)
+	}
+
+	ndjsonBody := `{"title": "nd one", "description": "a"}` + "\n" +
+		`{"title": 1, "description": "b"}` + "\n" +
+		"not json\n" +
+		`{"title": "nd two", "description": "c", "updated": "me"}` + "\n"
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/items/_import?format=ndjson", strings.NewReader(ndjsonBody))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	result = nil
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	expected := CR{"response": map[string]interface{}{
+		"imported": float64(2),
+		"errors": []interface{}{
+			map[string]interface{}{"line": float64(2), "error": "field title have invalid type"},
+			map[string]interface{}{"line": float64(3), "error": "invalid json"},
+		},
+	}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("bad ndjson import report\nGot : %#v\nWant: %#v", result, expected)
+	}
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/items",
+			Query:  "fields=title,updated&where[id][gt]=2",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{
+				{"title": "csv one", "updated": nil},
+				{"title": "nd one", "updated": nil},
+				{"title": "nd two", "updated": "me"},
+			}}},
+		},
+	})
+}
+
+func TestCursor(t *testing.T) {
+	ts := newTestServer(t, newTestDB(t, append(append([]string{}, testSchema...),
+		`INSERT INTO items (id, title, description) VALUES (3, 'memcache', ''), (4, 'aaa', ''), (5, 'zzz', '')`,
+	)...))
+
+	page := func(query string) (CR, string) {
+		resp, err := http.Get(ts.URL + "/items?" + query)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		defer resp.Body.Close()
+		var result struct {
+			Response struct {
+				Records    []CR   `json:"records"`
+				NextCursor string `json:"next_cursor"`
+			} `json:"response"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		ids := CR{}
+		for i, record := range result.Response.Records {
+			ids[strconv.Itoa(i)] = record["id"]
+		}
+		return ids, result.Response.NextCursor
+	}
+
+	var got []interface{}
+	query := "limit=2&fields=title&order=-title"
+	for i := 0; i < 5; i++ {
+		ids, next := page(query)
+		for j := 0; j < len(ids); j++ {
+			got = append(got, ids[strconv.Itoa(j)])
+		}
+		if next == "" {
+			break
+		}
+		query = "limit=2&fields=id,title&order=-title&cursor=" + next
+	}
+
+	// the first page has no id in fields; items 2 and 3 share a title and are ordered by id
+	expected := []interface{}{nil, nil, float64(3), float64(1), float64(4)}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("bad keyset pages\nGot : %v\nWant: %v", got, expected)
+	}
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/items",
+			Query:  "cursor=garbage",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "invalid cursor"},
+		},
+		{
+			Path:   "/items",
+			Query:  "order=title&cursor=eyJvIjoiaWQiLCJ2IjpbMV19",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "invalid cursor"},
+		},
+		{
+			Path:   "/items",
+			Query:  "order=updated&cursor=eyJvIjoiaWQiLCJ2IjpbMV19",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "cursor pagination needs a primary key and non-nullable order columns"},
+		},
+		{
+			Path:   "/items",
+			Query:  "fields=id&limit=2&cursor=eyJvIjoiaWQiLCJ2IjpbMV19",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"id": 2}, {"id": 3}}, "next_cursor": "eyJvIjoiaWQiLCJ2IjpbM119"}},
+		},
+	})
+}
+
+func TestCursorDateTime(t *testing.T) {
+	ts := newTestServer(t, newTestDB(t,
+		`CREATE TABLE posts (
+			id INTEGER PRIMARY KEY,
+			updated DATETIME NOT NULL
+		)`,
+		`INSERT INTO posts (id, updated) VALUES
+			(1, '2024-01-01 10:00:00'), (2, '2024-01-03 10:00:00'), (3, '2024-01-02 10:00:00'),
+			(4, '2024-01-03 10:00:00'), (5, '2024-01-04 10:00:00')`,
+	))
+
+	// sqlite reads DATETIME back as time.Time, the cursor has to compare it as the stored text.
+	// Rows with the same time are ordered by id
+	pages := func(order string) []interface{} {
+		var got []interface{}
+		query := "limit=2&fields=id&order=" + order
+		for i := 0; i < 5; i++ {
+			resp, err := http.Get(ts.URL + "/posts?" + query)
+			if err != nil {
+				t.Fatalf("request error: %v", err)
+			}
+			var result struct {
+				Response struct {
+					Records    []CR   `json:"records"`
+					NextCursor string `json:"next_cursor"`
+				} `json:"response"`
+			}
+			json.NewDecoder(resp.Body).Decode(&result)
+			resp.Body.Close()
+			for _, record := range result.Response.Records {
+				got = append(got, record["id"])
+			}
+			if result.Response.NextCursor == "" {
+				break
+			}
+			query = "limit=2&fields=id&order=" + order + "&cursor=" + result.Response.NextCursor
+		}
+		return got
+	}
+
+	for order, expected := range map[string][]interface{}{
+		"updated":  {float64(1), float64(3), float64(2), float64(4), float64(5)},
+		"-updated": {float64(5), float64(2), float64(4), float64(3), float64(1)},
+	} {
+		if got := pages(order); !reflect.DeepEqual(got, expected) {
+			t.Errorf("bad keyset pages for %s\nGot : %v\nWant: %v", order, got, expected)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ts := newTestServer(t, newTestDB(t, append(append([]string{}, testSchema...),
+		`INSERT INTO items (id, title, description) VALUES (3, '50% off', ''), (4, '500 off', '')`,
+	)...))
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/items/_search",
+			Query:  "q=SQL",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{
+				{"id": 1, "title": "database/sql", "description": "Рассказать про базы данных", "updated": "rvasily", "_matched": []string{"title"}},
+			}}},
+		},
+		{
+			Path:   "/items/_search",
+			Query:  "q=пример&fields=id",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{
+				{"id": 2, "_matched": []string{"description"}},
+			}}},
+		},
+		{
+			Path:   "/items/_search",
+			Query:  "q=rvasily&fields=title",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{
+				{"title": "database/sql", "_matched": []string{"updated"}},
+			}}},
+		},
+		{
+			// % and _ are searched literally
+			Path:   "/items/_search",
+			Query:  "q=50%25&fields=id",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{
+				{"id": 3, "_matched": []string{"title"}},
+			}}},
+		},
+		{
+			Path:   "/items/_search",
+			Query:  "q=off&fields=id&limit=1&order=-id",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{
+				"records":     []CR{{"id": 4, "_matched": []string{"title"}}},
+				"next_cursor": "eyJvIjoiLWlkIiwidiI6WzRdfQ",
+			}},
+		},
+		{
+			Path:   "/items/_search",
+			Query:  "q=off&fields=id&limit=1&order=-id&cursor=eyJvIjoiLWlkIiwidiI6WzRdfQ",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{
+				"records":     []CR{{"id": 3, "_matched": []string{"title"}}},
+				"next_cursor": "eyJvIjoiLWlkIiwidiI6WzNdfQ",
+			}},
+		},
+		{
+			Path:   "/items/_search",
+			Query:  "q=+",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "empty search query"},
+		},
+		{
+			Path:   "/nope/_search",
+			Query:  "q=x",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown table"},
+		},
+	})
+}
+
+func TestAudit(t *testing.T) {
+	policy, err := LoadPolicy(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatalf("cant load policy: %v", err)
+	}
+	handler, err := NewDbExplorer(newTestDB(t, testSchema...), WithPolicy(policy), WithAudit("audit_log"))
+	if err != nil {
+		t.Fatalf("cant create explorer: %v", err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/",
+			Token:  "admin-token",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"tables": []string{"items", "users"}}},
+		},
+		{
+			Method: http.MethodPut,
+			Path:   "/items",
+			Token:  "editor-token",
+			Body:   CR{"title": "audit", "description": "new"},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"id": 3}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/items/3",
+			Token:  "editor-token",
+			Body:   CR{"title": "audit", "description": "changed"},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		{
+			// a failed update leaves no trace
+			Method: http.MethodPost,
+			Path:   "/items/3",
+			Token:  "editor-token",
+			Body:   CR{"title": 1},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "field title have invalid type", "fields": CR{"title": "field title have invalid type"}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/_batch",
+			Token:  "admin-token",
+			Body:   CR{"operations": []CR{{"op": "delete", "table": "items", "id": 3}}},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"results": []CR{{"op": "delete", "table": "items", "deleted": 1}}}},
+		},
+		{
+			Path:   "/items/1/_history",
+			Token:  "editor-token",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"history": []CR{}}},
+		},
+		{
+			Path:   "/users/1/_history",
+			Status: http.StatusForbidden,
+			Result: CR{"error": "read access to users denied"},
+		},
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/items/3/_history", nil)
+	req.Header.Set("Authorization", "Bearer editor-token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	var result struct {
+		Response struct {
+			History []auditEntry `json:"history"`
+		} `json:"response"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+
+	editor := (&access{token: "editor-token", roleName: "editor"}).actor()
+	admin := (&access{token: "admin-token", roleName: "admin"}).actor()
+	if strings.Contains(editor, "editor-token") {
+		t.Errorf("token leaked into the audit: %s", editor)
+	}
+
+	expected := []auditEntry{
+		{Action: "create", Actor: editor, Changes: map[string]auditChange{
+			"id":          {nil, float64(3)},
+			"title":       {nil, "audit"},
+			"description": {nil, "new"},
+			"updated":     {nil, nil},
+		}},
+		{Action: "update", Actor: editor, Changes: map[string]auditChange{
+			"description": {"new", "changed"},
+		}},
+		{Action: "delete", Actor: admin, Changes: map[string]auditChange{
+			"id":          {float64(3), nil},
+			"title":       {"audit", nil},
+			"description": {"changed", nil},
+			"updated":     {nil, nil},
+		}},
+	}
+	history := result.Response.History
+	for i := range history {
+		if _, err := time.Parse(time.RFC3339, history[i].CreatedAt); err != nil {
+			t.Errorf("bad audit time %q", history[i].CreatedAt)
+		}
+		history[i].CreatedAt = ""
+	}
+	if !reflect.DeepEqual(history, expected) {
+		t.Errorf("bad history\nGot : %#v\nWant: %#v", history, expected)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	db := newTestDB(t, testSchema...)
+	ts := newTestServer(t, db)
+
+	getSpec := func() map[string]interface{} {
+		resp, err := http.Get(ts.URL + "/_openapi.json")
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		defer resp.Body.Close()
+		var spec map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+			t.Fatalf("bad spec: %v", err)
+		}
+		return spec
+	}
+
+	spec := getSpec()
+	if spec["openapi"] != "3.0.3" {
+		t.Errorf("bad openapi version: %v", spec["openapi"])
+	}
+	paths := spec["paths"].(map[string]interface{})
+	for _, path := range []string{"/", "/items", "/items/{id}", "/items/_search", "/users", "/users/{id}"} {
+		if _, ok := paths[path]; !ok {
+			t.Errorf("path %s is missing", path)
+		}
+	}
+	if _, ok := paths["/items"].(map[string]interface{})["put"]; !ok {
+		t.Errorf("create route is missing")
+	}
+
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	items := schemas["items"].(map[string]interface{})["properties"]
+	expected := map[string]interface{}{
+		"id":    map[string]interface{}{"type": "integer"},
+		"title": map[string]interface{}{"type": "string", "maxLength": float64(255)},
+		// sqlite text has no limit, only mysql text columns get maxLength
+		"description": map[string]interface{}{"type": "string"},
+		"updated":     map[string]interface{}{"type": "string", "maxLength": float64(255), "nullable": true},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("bad items schema\nGot : %#v\nWant: %#v", items, expected)
+	}
+	patch := schemas["itemsPatch"].(map[string]interface{})["properties"].(map[string]interface{})
+	if _, ok := patch["id"]; ok {
+		t.Errorf("primary key must not be in the update schema")
+	}
+
+	if _, err := db.Exec(`CREATE TABLE tags (id INTEGER PRIMARY KEY, name VARCHAR(32) NOT NULL)`); err != nil {
+		t.Fatalf("cant create table: %v", err)
+	}
+	runCases(t, ts, []Case{
+		{
+			Method: http.MethodPost,
+			Path:   "/_reload",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"tables": []string{"items", "tags", "users"}}},
+		},
+	})
+	if _, ok := getSpec()["paths"].(map[string]interface{})["/tags/{id}"]; !ok {
+		t.Errorf("spec does not follow the reloaded schema")
+	}
+}
+
+func TestExpand(t *testing.T) {
+	ts := newTestServer(t, newTestDB(t,
+		`CREATE TABLE authors (id INTEGER PRIMARY KEY, name VARCHAR(64) NOT NULL)`,
+		`CREATE TABLE posts (
+			id INTEGER PRIMARY KEY,
+			title VARCHAR(255) NOT NULL,
+			author_id INTEGER NOT NULL REFERENCES authors(id),
+			editor_id INTEGER REFERENCES authors
+		)`,
+		`INSERT INTO authors (id, name) VALUES (1, 'rvasily'), (2, 'gopher')`,
+		`INSERT INTO posts (id, title, author_id, editor_id) VALUES (1, 'sql', 1, 2), (2, 'memcache', 1, NULL), (3, 'grpc', 2, 1)`,
+	))
+
+	rvasily := CR{"id": 1, "name": "rvasily"}
+	gopher := CR{"id": 2, "name": "gopher"}
+	runCases(t, ts, []Case{
+		{
+			Path:   "/posts",
+			Query:  "expand=author_id,editor_id&fields=title",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{
+				{"title": "sql", "_expanded": CR{"author_id": rvasily, "editor_id": gopher}},
+				{"title": "memcache", "_expanded": CR{"author_id": rvasily, "editor_id": nil}},
+				{"title": "grpc", "_expanded": CR{"author_id": gopher, "editor_id": rvasily}},
+			}}},
+		},
+		{
+			Path:   "/posts/3",
+			Query:  "expand=author_id",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{
+				"id": 3, "title": "grpc", "author_id": 2, "editor_id": 1,
+				"_expanded": CR{"author_id": gopher},
+			}}},
+		},
+		{
+			Path:   "/posts",
+			Query:  "expand=title",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "column title has no foreign key"},
+		},
+		{
+			Path:   "/posts/1",
+			Query:  "expand=nope",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "unknown column nope"},
+		},
+	})
+}
+
+func TestETag(t *testing.T) {
+	ts := newTestServer(t, newTestDB(t, testSchema...))
+
+	etagOf := func(path string) string {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("request error: %v", err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("ETag")
+	}
+
+	original := etagOf("/items/2")
+	expected := recordETag(map[string]interface{}{
+		"id": 2, "title": "memcache", "description": "Рассказать про мемкеш с примером использования", "updated": nil,
+	})
+	if original != expected {
+		t.Fatalf("bad etag %s, want %s", original, expected)
+	}
+
+	runCases(t, ts, []Case{
+		{
+			Method: http.MethodPost,
+			Path:   "/items/2",
+			Header: map[string]string{"If-Match": original},
+			Body:   CR{"updated": "first"},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		{
+			// the second editor still has the etag of the original row
+			Method: http.MethodPost,
+			Path:   "/items/2",
+			Header: map[string]string{"If-Match": original},
+			Body:   CR{"updated": "second"},
+			Status: http.StatusPreconditionFailed,
+			Result: CR{"error": "record has been changed"},
+		},
+		{
+			Method: http.MethodDelete,
+			Path:   "/items/2",
+			Header: map[string]string{"If-Match": original},
+			Status: http.StatusPreconditionFailed,
+			Result: CR{"error": "record has been changed"},
+		},
+		{
+			Path:   "/items/2",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{
+				"id": 2, "title": "memcache", "description": "Рассказать про мемкеш с примером использования", "updated": "first",
+			}}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/items/42",
+			Header: map[string]string{"If-Match": "*"},
+			Body:   CR{"updated": "ghost"},
+			Status: http.StatusPreconditionFailed,
+			Result: CR{"error": "record has been changed"},
+		},
+	})
+
+	current := etagOf("/items/2")
+	if current == original {
+		t.Errorf("etag did not change after update")
+	}
+	runCases(t, ts, []Case{
+		{
+			Method: http.MethodDelete,
+			Path:   "/items/2",
+			Header: map[string]string{"If-Match": `"stale", ` + current},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"deleted": 1}},
+		},
+	})
+}
+
+func init() {
+	// slow(ms) sleeps inside a query, so a query timeout can be hit on purpose
+	sql.Register("sqlite3_slow", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("slow", func(ms int64) int64 {
+				time.Sleep(time.Duration(ms) * time.Millisecond)
+				return ms
+			}, true)
+		},
+	})
+}
+
+func TestTimeouts(t *testing.T) {
+	db, err := sql.Open("sqlite3_slow", filepath.Join(t.TempDir(), "explorer.db"))
+	if err != nil {
+		t.Fatalf("cant open db: %v", err)
+	}
+	defer db.Close()
+	for _, q := range []string{
+		`CREATE TABLE jobs (
+			id INTEGER PRIMARY KEY,
+			cost INTEGER NOT NULL,
+			delay INTEGER GENERATED ALWAYS AS (slow(cost)) VIRTUAL
+		)`,
+		`INSERT INTO jobs (id, cost) VALUES (1, 0), (2, 300)`,
+		`CREATE TABLE runs (id INTEGER PRIMARY KEY, job_id INTEGER NOT NULL REFERENCES jobs(id))`,
+		`INSERT INTO runs (id, job_id) VALUES (1, 1)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("cant prepare db: %v", err)
+		}
+	}
+
+	handler, err := NewDbExplorer(db, WithQueryTimeout(100*time.Millisecond), WithTableConcurrency(1))
+	if err != nil {
+		t.Fatalf("cant create explorer: %v", err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/jobs/1",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{"id": 1, "cost": 0, "delay": 0}}},
+		},
+		{
+			Path:   "/jobs/2",
+			Status: http.StatusGatewayTimeout,
+			Result: CR{"error": "context deadline exceeded"},
+		},
+	})
+
+	dbe := handler.(*DBExplorer)
+	release, err := dbe.acquire(context.Background(), "jobs")
+	if err != nil {
+		t.Fatalf("cant take a free slot: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := dbe.acquire(ctx, "jobs"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second request must wait for the slot, got %v", err)
+	}
+	if release, err := dbe.acquire(context.Background(), "other"); err != nil {
+		t.Errorf("other tables must not be limited: %v", err)
+	} else {
+		release()
+	}
+
+	// the slot is busy, so the requests time out before they reach the database
+	runCases(t, ts, []Case{
+		{
+			Path:   "/jobs/1",
+			Status: http.StatusGatewayTimeout,
+			Result: CR{"error": "context deadline exceeded"},
+		},
+		{
+			Path:   "/runs",
+			Query:  "expand=job_id",
+			Status: http.StatusGatewayTimeout,
+			Result: CR{"error": "context deadline exceeded"},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/_batch",
+			Body:   CR{"operations": []CR{{"op": "delete", "table": "jobs", "id": 2}}},
+			Status: http.StatusGatewayTimeout,
+			Result: CR{"error": "context deadline exceeded"},
+		},
+		{
+			Path:   "/runs",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"id": 1, "job_id": 1}}}},
+		},
+	})
+	release()
+
+	// a request in the middle of taking its slots gives the taken ones back when it is cancelled
+	release, err = dbe.acquire(context.Background(), "runs")
+	if err != nil {
+		t.Fatalf("cant take a free slot: %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	go cancel()
+	if _, err := dbe.acquire(ctx, "runs", "jobs"); errorCode(err) != 499 {
+		t.Errorf("expected a cancelled acquire, got %v", err)
+	}
+	release()
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/jobs/1",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{"id": 1, "cost": 0, "delay": 0}}},
+		},
+		{
+			Path:   "/runs/1",
+			Query:  "expand=job_id",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{"id": 1, "job_id": 1, "_expanded": CR{
+				"job_id": CR{"id": 1, "cost": 0, "delay": 0},
+			}}}},
+		},
+	})
+}
+
+func TestAggregate(t *testing.T) {
+	ts := newTestServer(t, newTestDB(t,
+		`CREATE TABLE orders (
+			id INTEGER PRIMARY KEY,
+			status VARCHAR(16) NOT NULL,
+			amount INTEGER NOT NULL,
+			age INTEGER
+		)`,
+		`INSERT INTO orders (status, amount, age) VALUES
+			('new', 10, 20), ('new', 30, NULL), ('paid', 5, 40), ('paid', 15, 30), ('paid', 100, 50)`,
+	))
+
+	runCases(t, ts, []Case{
+		{
+			Path:   "/orders/_aggregate",
+			Query:  "group=status&count=*&sum=amount&avg=age",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"groups": []CR{
+				{"status": "new", "count": 2, "sum_amount": 40, "avg_age": 20},
+				{"status": "paid", "count": 3, "sum_amount": 120, "avg_age": 40},
+			}}},
+		},
+		{
+			Path:   "/orders/_aggregate",
+			Query:  "count=age&max=status&where[amount][lt]=50",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"groups": []CR{
+				{"count_age": 3, "max_status": "paid"},
+			}}},
+		},
+		{
+			Path:   "/orders/_aggregate",
+			Query:  "sum=status",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "column status is not numeric"},
+		},
+		{
+			Path:   "/orders/_aggregate",
+			Query:  "group=nope&count=*",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "unknown column nope"},
+		},
+		{
+			Path:   "/orders/_aggregate",
+			Query:  "group=status",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "no aggregates"},
+		},
+		{
+			Path:   "/orders/_count",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"count": 5}},
+		},
+		{
+			Path:   "/orders/_count",
+			Query:  "where[status]=paid&where[amount][gte]=15&limit=1",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"count": 2}},
+		},
+		{
+			Path:   "/orders/_count",
+			Query:  "where[nope]=1",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "unknown column nope"},
+		},
+	})
+}
+
+func TestSoftDelete(t *testing.T) {
+	db := newTestDB(t, append(append([]string{}, testSchema...),
+		`CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT NOT NULL, deleted_at DATETIME DEFAULT NULL)`,
+		`INSERT INTO notes (id, body) VALUES (1, 'first'), (2, 'second'), (3, 'third')`,
+		`CREATE TABLE links (id INTEGER PRIMARY KEY, note_id INTEGER NOT NULL REFERENCES notes(id))`,
+		`INSERT INTO links (id, note_id) VALUES (1, 3)`,
+	)...)
+	ts := newTestServer(t, db)
+
+	runCases(t, ts, []Case{
+		{
+			Method: http.MethodDelete,
+			Path:   "/notes/1",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"deleted": 1}},
+		},
+		{
+			Method: http.MethodDelete,
+			Path:   "/notes/1",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"deleted": 0}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/_batch",
+			Body:   CR{"operations": []CR{{"op": "delete", "table": "notes", "id": 3}}},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"results": []CR{{"op": "delete", "table": "notes", "deleted": 1}}}},
+		},
+		{
+			Path:   "/notes",
+			Query:  "fields=body",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"body": "second"}}}},
+		},
+		{
+			Path:   "/notes/1",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "record not found"},
+		},
+		{
+			Path:   "/notes/_count",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"count": 1}},
+		},
+		{
+			Path:   "/notes/_count",
+			Query:  "with_deleted=1",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"count": 3}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/notes/3",
+			Body:   CR{"body": "changed"},
+			Status: http.StatusNotFound,
+			Result: CR{"error": "record not found"},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/notes",
+			Body:   []CR{{"id": 2, "body": "changed"}, {"id": 3, "body": "changed"}},
+			Status: http.StatusNotFound,
+			Result: CR{"error": "record 1: record not found", "index": 1},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/_batch",
+			Body:   CR{"operations": []CR{{"op": "update", "table": "notes", "id": 3, "data": CR{"body": "changed"}}}},
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error":   "operation 0: record not found",
+				"results": []CR{{"op": "update", "table": "notes", "error": "record not found"}},
+			},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/notes/3",
+			Query:  "with_deleted=1",
+			Body:   CR{"body": "third!"},
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"updated": 1}},
+		},
+		{
+			Path:   "/notes",
+			Query:  "fields=body&with_deleted=1&where[deleted_at][null]=false",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"body": "first"}, {"body": "third!"}}}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/notes/1/_restore",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"restored": 1}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/notes/1/_restore",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"restored": 0}},
+		},
+		{
+			Path:   "/notes/1",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{"id": 1, "body": "first", "deleted_at": nil}}},
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/items/1/_restore",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "table has no deleted_at column"},
+		},
+	})
+
+	// expanded relations hide deleted rows too
+	if _, err := db.Exec(`UPDATE notes SET deleted_at = '2020-01-02 03:04:05' WHERE id = 3`); err != nil {
+		t.Fatalf("cant update note: %v", err)
+	}
+	runCases(t, ts, []Case{
+		{
+			Path:   "/links/1",
+			Query:  "expand=note_id",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"record": CR{"id": 1, "note_id": 3, "_expanded": CR{"note_id": nil}}}},
+		},
+		{
+			Path:   "/links",
+			Query:  "expand=note_id&with_deleted=1",
+			Status: http.StatusOK,
+			Result: CR{"response": CR{"records": []CR{{"id": 1, "note_id": 3, "_expanded": CR{
+				"note_id": CR{"id": 3, "body": "third!", "deleted_at": "2020-01-02T03:04:05Z"},
+			}}}}},
+		},
+	})
+
+	// the row is still in the table
+	var deletedAt sql.NullString
+	if err := db.QueryRow(`SELECT deleted_at FROM notes WHERE id = 3`).Scan(&deletedAt); err != nil || !deletedAt.Valid {
+		t.Errorf("row 3 must be marked as deleted: %v %v", deletedAt, err)
+	}
+}
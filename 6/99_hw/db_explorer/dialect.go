@@ -0,0 +1,351 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect hides everything that differs between the supported databases:
+// schema introspection, identifier quoting, placeholders and insert ids
+type Dialect interface {
+	Name() string
+	Quote(ident string) string
+	Rebind(query string) string
+	Tables(q queryer) ([]string, error)
+	Columns(q queryer, tableName string) ([]Column, error)
+	Indexes(q queryer, tableName string) ([]Index, error)
+	ForeignKeys(q queryer, tableName string) ([]ForeignKey, error)
+	// UseReturning is true when the insert id comes from INSERT ... RETURNING instead of LastInsertId
+	UseReturning() bool
+	// SerialKey is the column definition of an auto increment primary key, used for service tables
+	SerialKey() string
+	// ForUpdate is the suffix that locks selected rows till the end of the transaction
+	ForUpdate() string
+}
+
+func detectDialect(db *sql.DB) (Dialect, error) {
+	driverType := fmt.Sprintf("%T", db.Driver())
+	switch {
+	case strings.Contains(driverType, "mysql."):
+		return mysqlDialect{}, nil
+	case strings.Contains(driverType, "pq."), strings.Contains(driverType, "stdlib."):
+		return postgresDialect{}, nil
+	case strings.Contains(driverType, "sqlite"):
+		return sqliteDialect{}, nil
+	}
+	return nil, fmt.Errorf("unsupported driver %s", driverType)
+}
+
+func queryStrings(q queryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+func quoteWith(ident, quote string) string {
+	return quote + strings.ReplaceAll(ident, quote, quote+quote) + quote
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string               { return "mysql" }
+func (mysqlDialect) Quote(ident string) string  { return quoteWith(ident, "`") }
+func (mysqlDialect) Rebind(query string) string { return query }
+func (mysqlDialect) UseReturning() bool         { return false }
+func (mysqlDialect) SerialKey() string          { return "BIGINT AUTO_INCREMENT PRIMARY KEY" }
+func (mysqlDialect) ForUpdate() string          { return " FOR UPDATE" }
+
+func (mysqlDialect) Tables(q queryer) ([]string, error) {
+	return queryStrings(q, "SHOW TABLES")
+}
+
+func (d mysqlDialect) Columns(q queryer, tableName string) ([]Column, error) {
+	rows, err := q.Query("SHOW FULL COLUMNS FROM " + d.Quote(tableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []Column{}
+	for rows.Next() {
+		var field, colType, collation, null, key, defaultVal, extra, privileges, comment sql.NullString
+		err := rows.Scan(&field, &colType, &collation, &null, &key, &defaultVal, &extra, &privileges, &comment)
+		if err != nil {
+			return nil, err
+		}
+
+		column := Column{
+			Name:         field.String,
+			Type:         colType.String,
+			IsNullable:   null.String == "YES",
+			IsPrimaryKey: key.String == "PRI",
+			Extra:        extra.String,
+			Comment:      comment.String,
+			Collation:    collation.String,
+		}
+		column.MaxBytes, _ = textLimit(parseColumnType(column.Type).Base)
+		if defaultVal.Valid {
+			column.Default = &defaultVal.String
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// SHOW COLUMNS does not tell the order of the key columns, the connection is free for the next query now
+	positions, err := q.Query(`SELECT COLUMN_NAME, SEQ_IN_INDEX
+		FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = 'PRIMARY'`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer positions.Close()
+	for positions.Next() {
+		var name string
+		var position int
+		if err := positions.Scan(&name, &position); err != nil {
+			return nil, err
+		}
+		for i := range columns {
+			if columns[i].Name == name {
+				columns[i].KeyPosition = position
+			}
+		}
+	}
+	return columns, positions.Err()
+}
+
+func (mysqlDialect) Indexes(q queryer, tableName string) ([]Index, error) {
+	rows, err := q.Query(`SELECT INDEX_NAME, NON_UNIQUE = 0, COLUMN_NAME, INDEX_TYPE
+		FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+		ORDER BY INDEX_NAME, SEQ_IN_INDEX`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return scanIndexes(rows)
+}
+
+func (mysqlDialect) ForeignKeys(q queryer, tableName string) ([]ForeignKey, error) {
+	rows, err := q.Query(`SELECT CONSTRAINT_NAME, COLUMN_NAME, REFERENCED_TABLE_NAME, REFERENCED_COLUMN_NAME
+		FROM information_schema.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND REFERENCED_TABLE_NAME IS NOT NULL
+		ORDER BY CONSTRAINT_NAME, ORDINAL_POSITION`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return scanForeignKeys(rows)
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string              { return "postgres" }
+func (postgresDialect) Quote(ident string) string { return quoteWith(ident, `"`) }
+func (postgresDialect) UseReturning() bool        { return true }
+func (postgresDialect) SerialKey() string         { return "BIGSERIAL PRIMARY KEY" }
+func (postgresDialect) ForUpdate() string         { return " FOR UPDATE" }
+
+// Rebind numbers the placeholders, a ? inside a string literal or a quoted identifier is left as is
+func (postgresDialect) Rebind(query string) string {
+	var sb strings.Builder
+	n := 0
+	var quote rune
+	for _, ch := range query {
+		switch {
+		case quote != 0:
+			// a doubled quote closes and opens the literal again, so it needs no special case
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '?':
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(ch)
+	}
+	return sb.String()
+}
+
+func (postgresDialect) Tables(q queryer) ([]string, error) {
+	return queryStrings(q, `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+		ORDER BY table_name`)
+}
+
+func (postgresDialect) Columns(q queryer, tableName string) ([]Column, error) {
+	rows, err := q.Query(`SELECT c.column_name, c.data_type, c.character_maximum_length,
+			c.numeric_precision, c.numeric_scale, c.is_nullable, c.column_default, c.is_identity,
+			COALESCE(c.collation_name, ''), COALESCE(col_description(to_regclass(quote_ident(c.table_name))::oid, c.ordinal_position::int), ''),
+			COALESCE((
+				SELECT array_position(ix.indkey::int2[], a.attnum)
+				FROM pg_index ix
+				JOIN pg_attribute a ON a.attrelid = ix.indrelid
+				WHERE ix.indrelid = to_regclass(quote_ident(c.table_name)) AND ix.indisprimary
+					AND a.attname = c.column_name
+			), 0)
+		FROM information_schema.columns c
+		WHERE c.table_schema = current_schema() AND c.table_name = $1
+		ORDER BY c.ordinal_position`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []Column{}
+	for rows.Next() {
+		var column Column
+		var dataType, nullable, identity string
+		var charLen, precision, scale sql.NullInt64
+		var defaultVal sql.NullString
+		err := rows.Scan(&column.Name, &dataType, &charLen, &precision, &scale, &nullable, &defaultVal, &identity,
+			&column.Collation, &column.Comment, &column.KeyPosition)
+		if err != nil {
+			return nil, err
+		}
+		column.IsPrimaryKey = column.KeyPosition > 0
+
+		column.Type = dataType
+		if charLen.Valid {
+			column.Type = fmt.Sprintf("%s(%d)", dataType, charLen.Int64)
+		} else if dataType == "numeric" && precision.Valid {
+			column.Type = fmt.Sprintf("numeric(%d,%d)", precision.Int64, scale.Int64)
+		}
+		column.IsNullable = nullable == "YES"
+		if defaultVal.Valid {
+			column.Default = &defaultVal.String
+		}
+		if identity == "YES" || strings.HasPrefix(defaultVal.String, "nextval(") {
+			column.Extra = "auto_increment"
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+func (postgresDialect) Indexes(q queryer, tableName string) ([]Index, error) {
+	rows, err := q.Query(`SELECT i.relname, ix.indisunique, a.attname, am.amname
+		FROM pg_class t
+		JOIN pg_index ix ON ix.indrelid = t.oid
+		JOIN pg_class i ON i.oid = ix.indexrelid
+		JOIN pg_am am ON am.oid = i.relam
+		JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = ANY(ix.indkey)
+		WHERE t.relname = $1 AND t.relnamespace = to_regnamespace(current_schema())
+		ORDER BY i.relname, array_position(ix.indkey::int2[], a.attnum)`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return scanIndexes(rows)
+}
+
+func (postgresDialect) ForeignKeys(q queryer, tableName string) ([]ForeignKey, error) {
+	rows, err := q.Query(`SELECT tc.constraint_name, kcu.column_name, ccu.table_name, ccu.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+			ON kcu.constraint_schema = tc.constraint_schema AND kcu.constraint_name = tc.constraint_name
+		JOIN information_schema.constraint_column_usage ccu
+			ON ccu.constraint_schema = tc.constraint_schema AND ccu.constraint_name = tc.constraint_name
+		WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = current_schema() AND tc.table_name = $1
+		ORDER BY tc.constraint_name, kcu.ordinal_position`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return scanForeignKeys(rows)
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string               { return "sqlite" }
+func (sqliteDialect) Quote(ident string) string  { return quoteWith(ident, `"`) }
+func (sqliteDialect) Rebind(query string) string { return query }
+func (sqliteDialect) UseReturning() bool         { return false }
+func (sqliteDialect) SerialKey() string          { return "INTEGER PRIMARY KEY" }
+
+// sqlite has no row locks, a write transaction locks the whole database
+func (sqliteDialect) ForUpdate() string { return "" }
+
+func (sqliteDialect) Tables(q queryer) ([]string, error) {
+	return queryStrings(q, `SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%'
+		ORDER BY name`)
+}
+
+func (sqliteDialect) Columns(q queryer, tableName string) ([]Column, error) {
+	rows, err := q.Query(`SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_info(?)`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []Column{}
+	for rows.Next() {
+		var column Column
+		var notNull, pk int
+		var defaultVal sql.NullString
+		if err := rows.Scan(&column.Name, &column.Type, &notNull, &defaultVal, &pk); err != nil {
+			return nil, err
+		}
+
+		column.IsNullable = notNull == 0
+		// pk is the position in the primary key
+		column.IsPrimaryKey = pk > 0
+		column.KeyPosition = pk
+		if defaultVal.Valid {
+			column.Default = &defaultVal.String
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// INTEGER PRIMARY KEY is an alias for rowid and is filled in automatically, a part of a composite key is not
+	var keyColumns []int
+	for i, column := range columns {
+		if column.IsPrimaryKey {
+			keyColumns = append(keyColumns, i)
+		}
+	}
+	if len(keyColumns) == 1 && strings.EqualFold(columns[keyColumns[0]].Type, "integer") {
+		columns[keyColumns[0]].Extra = "auto_increment"
+	}
+	return columns, nil
+}
+
+func (sqliteDialect) Indexes(q queryer, tableName string) ([]Index, error) {
+	rows, err := q.Query(`SELECT il.name, il."unique", ii.name, il.origin
+		FROM pragma_index_list(?) il, pragma_index_info(il.name) ii
+		ORDER BY il.name, ii.seqno`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return scanIndexes(rows)
+}
+
+func (sqliteDialect) ForeignKeys(q queryer, tableName string) ([]ForeignKey, error) {
+	rows, err := q.Query(`SELECT 'fk_' || id, "from", "table", COALESCE("to", '')
+		FROM pragma_foreign_key_list(?)
+		ORDER BY id, seq`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return scanForeignKeys(rows)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// recordETag is a strong etag of the full row, hidden columns included, so any change of the row changes it
+func recordETag(record map[string]interface{}) string {
+	data, _ := json.Marshal(record)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// checkIfMatch compares If-Match with the row as it is inside the transaction. fetchRecord locks the row,
+// so nobody can change it between the check and the write. An empty header skips the check
+func (dbe *DBExplorer) checkIfMatch(q queryer, table Table, key recordKey, ifMatch string) error {
+	if ifMatch == "" {
+		return nil
+	}
+
+	record, err := dbe.fetchRecord(q, table, key)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return &apiError{412, "record has been changed"}
+	}
+
+	current := recordETag(record)
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return nil
+		}
+	}
+	return &apiError{412, "record has been changed"}
+}
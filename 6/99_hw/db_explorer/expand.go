@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseExpand resolves ?expand=col1,col2 into the foreign keys of those columns
+func parseExpand(table Table, raw string) ([]ForeignKey, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var fks []ForeignKey
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := table.findColumn(name); !ok {
+			return nil, fmt.Errorf("unknown column %s", name)
+		}
+
+		var found []ForeignKey
+		for _, fk := range table.ForeignKeys {
+			if fk.Column == name {
+				found = append(found, fk)
+			}
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("column %s has no foreign key", name)
+		}
+		for _, fk := range table.ForeignKeys {
+			if fk.Name == found[0].Name && fk.Column != name {
+				return nil, fmt.Errorf("composite foreign key %s can not be expanded", fk.Name)
+			}
+		}
+		fks = append(fks, found[0])
+	}
+	return fks, nil
+}
+
+func expandColumns(fks []ForeignKey) []string {
+	columns := make([]string, 0, len(fks))
+	for _, fk := range fks {
+		columns = append(columns, fk.Column)
+	}
+	return columns
+}
+
+// expandRecords puts the referenced rows into record["_expanded"][column], with one IN (...) query per relation.
+// Soft deleted rows are left out like in the list, unless withDeleted is set
+func (dbe *DBExplorer) expandRecords(q queryer, acc *access, fks []ForeignKey, records []map[string]interface{}, withDeleted bool) error {
+	if len(fks) == 0 || len(records) == 0 {
+		return nil
+	}
+
+	expanded := make([]map[string]interface{}, len(records))
+	for i := range records {
+		expanded[i] = map[string]interface{}{}
+	}
+
+	for _, fk := range fks {
+		refTable, ok := dbe.table(fk.RefTable)
+		if !ok {
+			return &apiError{400, fmt.Sprintf("unknown table %s", fk.RefTable)}
+		}
+		if err := acc.canRead(fk.RefTable); err != nil {
+			return &apiError{403, err.Error()}
+		}
+
+		// sqlite leaves the column out when the reference is to the primary key
+		refColumn := fk.RefColumn
+		if refColumn == "" {
+			if len(refTable.PrimaryKey) != 1 {
+				return &apiError{400, fmt.Sprintf("column %s has no foreign key", fk.Column)}
+			}
+			refColumn = refTable.PrimaryKey[0]
+		}
+		if acc.isHidden(fk.RefTable, refColumn) {
+			return &apiError{403, fmt.Sprintf("column %s is hidden", refColumn)}
+		}
+
+		seen := map[string]bool{}
+		var values []interface{}
+		for _, record := range records {
+			value := record[fk.Column]
+			if value != nil && !seen[fmt.Sprint(value)] {
+				seen[fmt.Sprint(value)] = true
+				values = append(values, value)
+			}
+		}
+
+		related := map[string]map[string]interface{}{}
+		if len(values) > 0 {
+			placeholders := strings.Repeat("?,", len(values))
+			query := fmt.Sprintf("SELECT * FROM %s WHERE %s IN (%s)",
+				dbe.dialect.Quote(fk.RefTable), dbe.dialect.Quote(refColumn), placeholders[:len(placeholders)-1])
+			if refTable.softDelete() && !withDeleted {
+				query += " AND " + dbe.dialect.Quote(deletedAtColumn) + " IS NULL"
+			}
+			rows, err := q.Query(dbe.dialect.Rebind(query), values...)
+			if err != nil {
+				return err
+			}
+			rowsData, err := dbe.readRows(rows)
+			if err != nil {
+				return err
+			}
+			for _, row := range rowsData {
+				related[fmt.Sprint(row[refColumn])] = row
+			}
+			acc.maskRecords(fk.RefTable, rowsData)
+		}
+
+		for i, record := range records {
+			var row map[string]interface{}
+			if value := record[fk.Column]; value != nil {
+				row = related[fmt.Sprint(value)]
+			}
+			expanded[i][fk.Column] = row
+		}
+	}
+
+	for i, record := range records {
+		record["_expanded"] = expanded[i]
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	exportFlushEvery = 100
+	importChunkSize  = 100
+)
+
+type importError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type importRecord struct {
+	Line int
+	Data map[string]interface{}
+}
+
+// lineError is a broken line in the input, it goes to the report and the import continues
+type lineError struct {
+	Line int
+	Err  error
+}
+
+func (e *lineError) Error() string {
+	return e.Err.Error()
+}
+
+// exportData streams rows straight from the cursor, so the whole table is never held in memory.
+// The slot of WithTableConcurrency stays taken until the last row is sent
+func (dbe *DBExplorer) exportData(w http.ResponseWriter, r *http.Request, tableName string) {
+	acc := accessFrom(r.Context())
+	if err := acc.canRead(tableName); err != nil {
+		dbe.sendError(w, err.Error(), 403)
+		return
+	}
+
+	table, ok := dbe.table(tableName)
+	if !ok {
+		dbe.sendError(w, "unknown table", 404)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "csv" && format != "ndjson" {
+		dbe.sendError(w, "unknown format", 400)
+		return
+	}
+
+	lq, err := parseListQuery(table, r.URL.Query())
+	if err != nil {
+		dbe.sendError(w, err.Error(), 400)
+		return
+	}
+	if err := acc.checkColumns(tableName, lq.columns()); err != nil {
+		dbe.sendError(w, err.Error(), 403)
+		return
+	}
+	lq.Unlimited = true
+
+	query, args := lq.sql(dbe.dialect, tableName)
+	rows, err := dbe.conn(r.Context()).Query(dbe.dialect.Rebind(query), args...)
+	if err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+	// keyset columns added to the select are not part of the export
+	missing := lq.missingFields()
+	var visible []string
+	for _, column := range columns {
+		if !acc.isHidden(tableName, column) && !slices.Contains(missing, column) {
+			visible = append(visible, column)
+		}
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", tableName+"."+format))
+
+	flusher, _ := w.(http.Flusher)
+	csvWriter := csv.NewWriter(w)
+	encoder := json.NewEncoder(w)
+	if format == "csv" {
+		csvWriter.Write(visible)
+	}
+
+	for n := 1; rows.Next(); n++ {
+		data, err := scanRow(rows, columns)
+		if err != nil {
+			// headers are already sent, the only thing left is to cut the stream
+			panic(http.ErrAbortHandler)
+		}
+
+		if format == "csv" {
+			line := make([]string, len(visible))
+			for i, column := range visible {
+				line[i] = csvString(data[column])
+			}
+			csvWriter.Write(line)
+		} else {
+			for _, extra := range missing {
+				delete(data, extra)
+			}
+			acc.maskRecords(tableName, []map[string]interface{}{data})
+			encoder.Encode(data)
+		}
+
+		if n%exportFlushEvery == 0 {
+			csvWriter.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	csvWriter.Flush()
+	if rows.Err() != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func csvString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprint(value)
+}
+
+// csvValue turns a csv cell into what json decoding would have produced, so validateValue handles both formats
+func csvValue(col Column, raw string) interface{} {
+	if raw == "" && col.IsNullable {
+		return nil
+	}
+
+	ct := parseColumnType(col.Type)
+	if ct.isInteger() || ct.isFloat() {
+		if num, err := strconv.ParseFloat(raw, 64); err == nil {
+			return num
+		}
+	}
+	return raw
+}
+
+func (dbe *DBExplorer) importData(w http.ResponseWriter, r *http.Request, tableName string) {
+	acc := accessFrom(r.Context())
+	if err := acc.canWrite(tableName); err != nil {
+		dbe.sendError(w, err.Error(), 403)
+		return
+	}
+
+	table, ok := dbe.table(tableName)
+	if !ok {
+		dbe.sendError(w, "unknown table", 404)
+		return
+	}
+
+	var next func() (importRecord, error)
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		reader := csv.NewReader(r.Body)
+		reader.FieldsPerRecord = -1
+		header, err := reader.Read()
+		if err != nil {
+			dbe.sendError(w, "invalid csv header", 400)
+			return
+		}
+		next = func() (importRecord, error) {
+			line, err := reader.Read()
+			if pe, ok := err.(*csv.ParseError); ok {
+				return importRecord{}, &lineError{pe.Line, pe.Err}
+			}
+			if err != nil {
+				return importRecord{}, err
+			}
+			lineNum, _ := reader.FieldPos(0)
+			data := make(map[string]interface{}, len(header))
+			for i, name := range header {
+				if col, ok := table.findColumn(name); ok && i < len(line) {
+					data[name] = csvValue(col, line[i])
+				}
+			}
+			return importRecord{lineNum, data}, nil
+		}
+
+	case "ndjson":
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		lineNum := 0
+		next = func() (importRecord, error) {
+			for scanner.Scan() {
+				lineNum++
+				if len(scanner.Bytes()) == 0 {
+					continue
+				}
+				var data map[string]interface{}
+				if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
+					return importRecord{}, &lineError{lineNum, fmt.Errorf("invalid json")}
+				}
+				return importRecord{lineNum, data}, nil
+			}
+			if err := scanner.Err(); err != nil {
+				return importRecord{}, err
+			}
+			return importRecord{}, io.EOF
+		}
+
+	default:
+		dbe.sendError(w, "unknown format", 400)
+		return
+	}
+
+	imported := 0
+	report := []importError{}
+	chunk := make([]importRecord, 0, importChunkSize)
+
+	flush := func() {
+		n, errs := dbe.importChunk(r.Context(), acc, table, chunk)
+		imported += n
+		report = append(report, errs...)
+		chunk = chunk[:0]
+	}
+
+	for {
+		rec, err := next()
+		if err == io.EOF {
+			break
+		}
+		if le, ok := err.(*lineError); ok {
+			report = append(report, importError{le.Line, le.Error()})
+			continue
+		}
+		if err != nil {
+			dbe.sendError(w, err.Error(), 400)
+			return
+		}
+
+		if err := acc.checkWrite(tableName, rec.Data); err != nil {
+			report = append(report, importError{rec.Line, err.Error()})
+			continue
+		}
+
+		chunk = append(chunk, rec)
+		if len(chunk) == importChunkSize {
+			flush()
+		}
+	}
+	flush()
+	sort.Slice(report, func(i, j int) bool { return report[i].Line < report[j].Line })
+
+	var response = map[string]interface{}{
+		"response": map[string]interface{}{
+			"imported": imported,
+			"errors":   report,
+		},
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// importChunk inserts records in one transaction. Validation errors only skip their own line,
+// but a database error may break the transaction, so then the chunk is retried record by record
+func (dbe *DBExplorer) importChunk(ctx context.Context, acc *access, table Table, chunk []importRecord) (int, []importError) {
+	if len(chunk) == 0 {
+		return 0, nil
+	}
+
+	var report []importError
+	imported := 0
+	err := dbe.inTx(ctx, func(tx queryer) error {
+		for _, rec := range chunk {
+			_, err := dbe.insertRecord(tx, acc, table, rec.Data)
+			if err == nil {
+				imported++
+				continue
+			}
+			if errorCode(err) == 500 {
+				return err
+			}
+			report = append(report, importError{rec.Line, err.Error()})
+		}
+		return nil
+	})
+	if err == nil {
+		return imported, report
+	}
+
+	report, imported = nil, 0
+	for _, rec := range chunk {
+		err := dbe.inTx(ctx, func(tx queryer) error {
+			_, err := dbe.insertRecord(tx, acc, table, rec.Data)
+			return err
+		})
+		if err != nil {
+			report = append(report, importError{rec.Line, err.Error()})
+			continue
+		}
+		imported++
+	}
+	return imported, report
+}
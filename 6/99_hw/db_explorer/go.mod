@@ -4,6 +4,9 @@ go 1.21.0
 
 toolchain go1.24.2
 
-require github.com/go-sql-driver/mysql v1.9.3
+require (
+	github.com/go-sql-driver/mysql v1.9.3
+	github.com/mattn/go-sqlite3 v1.14.32
+)
 
 require filippo.io/edwards25519 v1.1.0 // indirect
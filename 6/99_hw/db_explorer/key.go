@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// recordKey holds primary key values in the order of Table.PrimaryKey
+type recordKey []interface{}
+
+func (t Table) keyColumns() []Column {
+	columns := make([]Column, 0, len(t.PrimaryKey))
+	for _, name := range t.PrimaryKey {
+		col, _ := t.findColumn(name)
+		columns = append(columns, col)
+	}
+	return columns
+}
+
+func (t Table) isKeyColumn(name string) bool {
+	for _, key := range t.PrimaryKey {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t Table) hasAutoIncrementKey() bool {
+	columns := t.keyColumns()
+	return len(columns) == 1 && columns[0].isAutoIncrement()
+}
+
+// parseKey reads the key from the path (/$table/k1,k2) or, for /$table/_key, from query params named after the key columns
+func parseKey(table Table, idStr string, params url.Values) (recordKey, error) {
+	if len(table.PrimaryKey) == 0 {
+		return nil, &apiError{400, "table has no primary key"}
+	}
+
+	var parts []string
+	if idStr == "_key" {
+		for _, name := range table.PrimaryKey {
+			if !params.Has(name) {
+				return nil, &apiError{400, fmt.Sprintf("missing key field %s", name)}
+			}
+			parts = append(parts, params.Get(name))
+		}
+	} else {
+		parts = strings.Split(idStr, ",")
+		if len(parts) != len(table.PrimaryKey) {
+			return nil, &apiError{400, "invalid id"}
+		}
+	}
+
+	key := make(recordKey, 0, len(parts))
+	for i, col := range table.keyColumns() {
+		value, err := parseKeyValue(col, parts[i])
+		if err != nil {
+			return nil, err
+		}
+		key = append(key, value)
+	}
+	return key, nil
+}
+
+func parseKeyValue(col Column, raw string) (interface{}, error) {
+	ct := parseColumnType(col.Type)
+	switch {
+	case ct.isInteger():
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, &apiError{400, "invalid id"}
+		}
+		return id, nil
+	case ct.isFloat():
+		id, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, &apiError{400, "invalid id"}
+		}
+		return id, nil
+	}
+
+	value, err := validateValue(col, raw)
+	if err != nil {
+		return nil, &apiError{400, "invalid id"}
+	}
+	return value, nil
+}
+
+// keyFromValue accepts a key as it comes in json: a scalar, "k1,k2", [k1, k2] or {"col": value}
+func keyFromValue(table Table, value interface{}) (recordKey, error) {
+	switch v := value.(type) {
+	case string:
+		return parseKey(table, v, nil)
+	case map[string]interface{}:
+		return keyFromData(table, v)
+	case []interface{}:
+		if len(v) != len(table.PrimaryKey) {
+			return nil, &apiError{400, "invalid id"}
+		}
+		data := make(map[string]interface{}, len(v))
+		for i, name := range table.PrimaryKey {
+			data[name] = v[i]
+		}
+		return keyFromData(table, data)
+	case nil:
+		return nil, &apiError{400, "invalid id"}
+	}
+	if len(table.PrimaryKey) != 1 {
+		return nil, &apiError{400, "invalid id"}
+	}
+	return keyFromData(table, map[string]interface{}{table.PrimaryKey[0]: value})
+}
+
+func keyFromData(table Table, data map[string]interface{}) (recordKey, error) {
+	if len(table.PrimaryKey) == 0 {
+		return nil, &apiError{400, "table has no primary key"}
+	}
+
+	key := make(recordKey, 0, len(table.PrimaryKey))
+	for _, col := range table.keyColumns() {
+		raw, ok := data[col.Name]
+		if !ok || raw == nil {
+			return nil, &apiError{400, fmt.Sprintf("missing key field %s", col.Name)}
+		}
+		value, err := validateValue(col, raw)
+		if err != nil {
+			return nil, &apiError{400, "invalid id"}
+		}
+		key = append(key, value)
+	}
+	return key, nil
+}
+
+// value is what goes into json responses: the bare value for a single column key, a map otherwise
+func (key recordKey) value(table Table) interface{} {
+	if len(key) == 1 {
+		return key[0]
+	}
+	return key.fields(table)
+}
+
+func (key recordKey) fields(table Table) map[string]interface{} {
+	result := make(map[string]interface{}, len(key))
+	for i, name := range table.PrimaryKey {
+		result[name] = key[i]
+	}
+	return result
+}
+
+func normalizeKey(key recordKey) recordKey {
+	for i, v := range key {
+		if b, ok := v.([]byte); ok {
+			key[i] = string(b)
+		}
+	}
+	return key
+}
+
+func (dbe *DBExplorer) keyWhere(table Table) string {
+	conds := make([]string, 0, len(table.PrimaryKey))
+	for _, name := range table.PrimaryKey {
+		conds = append(conds, dbe.dialect.Quote(name)+" = ?")
+	}
+	return strings.Join(conds, " AND ")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// WithQueryTimeout sets the deadline of every request, queries still running at the deadline
+// are cancelled and the client gets 504
+func WithQueryTimeout(timeout time.Duration) Option {
+	return func(dbe *DBExplorer) {
+		dbe.queryTimeout = timeout
+	}
+}
+
+// WithTableConcurrency limits how many requests may query one table at the same time,
+// the rest wait for a free slot until their deadline, so one heavy table can not take the whole pool.
+// Tables of ?expand= and of _batch operations take their slots too
+func WithTableConcurrency(limit int) Option {
+	return func(dbe *DBExplorer) {
+		dbe.tableLimit = limit
+	}
+}
+
+// acquire takes a slot of every table and returns the function that frees them. All the tables of one request
+// are taken at once in name order, so two requests that need the same tables can not wait for each other.
+// The slots are held until the response is written, for _export that is the whole stream
+func (dbe *DBExplorer) acquire(ctx context.Context, tableNames ...string) (func(), error) {
+	if dbe.tableLimit <= 0 {
+		return func() {}, nil
+	}
+
+	names := slices.Clone(tableNames)
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	var taken []chan struct{}
+	release := func() {
+		for _, slots := range taken {
+			<-slots
+		}
+	}
+	for _, name := range names {
+		slots := dbe.tableSlots(name)
+		select {
+		case slots <- struct{}{}:
+			taken = append(taken, slots)
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+func (dbe *DBExplorer) tableSlots(tableName string) chan struct{} {
+	dbe.slotsMu.Lock()
+	defer dbe.slotsMu.Unlock()
+
+	slots, ok := dbe.slots[tableName]
+	if !ok {
+		slots = make(chan struct{}, dbe.tableLimit)
+		dbe.slots[tableName] = slots
+	}
+	return slots
+}
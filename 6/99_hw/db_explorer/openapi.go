@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type object = map[string]interface{}
+
+// showOpenAPI builds the OpenAPI 3 document from the current schema on every request,
+// so it follows /_reload. Tables, columns and methods the caller has no access to are left out
+func (dbe *DBExplorer) showOpenAPI(w http.ResponseWriter, r *http.Request) {
+	acc := accessFrom(r.Context())
+
+	var tableNames []string
+	for tableName := range dbe.schema() {
+		if acc.canRead(tableName) == nil {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	sort.Strings(tableNames)
+
+	paths := object{
+		"/": object{
+			"get": operation("List tables", nil, nil, responseSchema(object{
+				"tables": object{"type": "array", "items": object{"type": "string"}},
+			})),
+		},
+	}
+	schemas := object{
+		"Error": object{
+			"type":       "object",
+			"properties": object{"error": object{"type": "string"}},
+		},
+	}
+
+	for _, tableName := range tableNames {
+		table := acc.maskTable(dbe.schema()[tableName])
+		writable := acc.canWrite(tableName) == nil
+		ref := refSchema(tableName)
+		schemas[tableName] = recordSchema(table)
+		if writable {
+			schemas[tableName+"Input"] = inputSchema(acc, table, false)
+			schemas[tableName+"Patch"] = inputSchema(acc, table, true)
+		}
+
+		list := responseSchema(object{
+			"records":     object{"type": "array", "items": ref},
+			"next_cursor": object{"type": "string"},
+		})
+		collection := object{
+			"get": operation("List "+tableName, listParameters(), nil, list),
+		}
+		search := object{
+			"get": operation("Search "+tableName, append(listParameters(),
+				queryParameter("q", "text to look for in text columns", true)), nil, list),
+		}
+		item := object{
+			"parameters": []interface{}{idParameter(table)},
+			"get": operation("Get "+tableName+" record", []interface{}{expandParameter()}, nil, responseSchema(object{
+				"record": ref,
+			})),
+		}
+
+		if writable {
+			keyProperties := object{}
+			for _, col := range table.keyColumns() {
+				keyProperties[col.Name] = columnSchema(col)
+			}
+			collection["put"] = operation("Create "+tableName+" record", nil,
+				refSchema(tableName+"Input"), responseSchema(keyProperties))
+			collection["post"] = operation("Update "+tableName+" records by their primary keys", nil,
+				object{"type": "array", "items": refSchema(tableName + "Input")},
+				responseSchema(object{"updated": object{"type": "integer"}}))
+			item["post"] = operation("Update "+tableName+" record", nil,
+				refSchema(tableName+"Patch"), responseSchema(object{"updated": object{"type": "integer"}}))
+			item["delete"] = operation("Delete "+tableName+" record", nil,
+				nil, responseSchema(object{"deleted": object{"type": "integer"}}))
+		}
+
+		paths["/"+tableName] = collection
+		paths["/"+tableName+"/_search"] = search
+		paths["/"+tableName+"/_count"] = object{
+			"get": operation("Count "+tableName+" records matching where[...] filters", nil, nil,
+				responseSchema(object{"count": object{"type": "integer"}})),
+		}
+		paths["/"+tableName+"/_aggregate"] = object{
+			"get": operation("Aggregate "+tableName, []interface{}{
+				queryParameter("group", "comma separated columns to group by", false),
+				queryParameter("count", "* or comma separated columns", false),
+				queryParameter("sum", "comma separated numeric columns", false),
+				queryParameter("avg", "comma separated numeric columns", false),
+				queryParameter("min", "comma separated columns", false),
+				queryParameter("max", "comma separated columns", false),
+			}, nil, responseSchema(object{
+				"groups": object{"type": "array", "items": object{"type": "object"}},
+			})),
+		}
+		if len(table.PrimaryKey) > 0 {
+			paths["/"+tableName+"/{id}"] = item
+		}
+		if writable && table.softDelete() && len(table.PrimaryKey) > 0 {
+			paths["/"+tableName+"/{id}/_restore"] = object{
+				"parameters": []interface{}{idParameter(table)},
+				"post": operation("Restore soft deleted "+tableName+" record", nil, nil,
+					responseSchema(object{"restored": object{"type": "integer"}})),
+			}
+		}
+		if dbe.audit != "" && len(table.PrimaryKey) > 0 {
+			paths["/"+tableName+"/{id}/_history"] = object{
+				"parameters": []interface{}{idParameter(table)},
+				"get": operation("Change history of "+tableName+" record", nil, nil, responseSchema(object{
+					"history": object{"type": "array", "items": object{"type": "object"}},
+				})),
+			}
+		}
+	}
+
+	spec := object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   "db_explorer",
+			"version": "1.0",
+		},
+		"paths":      paths,
+		"components": object{"schemas": schemas},
+	}
+	json.NewEncoder(w).Encode(spec)
+}
+
+func refSchema(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+// responseSchema wraps properties into {"response": {...}} as every handler does
+func responseSchema(properties object) object {
+	return object{
+		"type": "object",
+		"properties": object{
+			"response": object{"type": "object", "properties": properties},
+		},
+	}
+}
+
+func operation(summary string, parameters []interface{}, body, response object) object {
+	op := object{
+		"summary": summary,
+		"responses": object{
+			"200": object{
+				"description": "OK",
+				"content":     object{"application/json": object{"schema": response}},
+			},
+			"default": object{
+				"description": "Error",
+				"content":     object{"application/json": object{"schema": refSchema("Error")}},
+			},
+		},
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+	if body != nil {
+		op["requestBody"] = object{
+			"required": true,
+			"content":  object{"application/json": object{"schema": body}},
+		}
+	}
+	return op
+}
+
+func queryParameter(name, description string, required bool) object {
+	return object{
+		"name":        name,
+		"in":          "query",
+		"description": description,
+		"required":    required,
+		"schema":      object{"type": "string"},
+	}
+}
+
+func listParameters() []interface{} {
+	return []interface{}{
+		object{"name": "limit", "in": "query", "schema": object{"type": "integer", "default": 5}},
+		object{"name": "offset", "in": "query", "schema": object{"type": "integer", "default": 0}},
+		queryParameter("fields", "comma separated columns to return", false),
+		queryParameter("order", "comma separated columns, - for descending order", false),
+		queryParameter("cursor", "next_cursor of the previous page", false),
+		expandParameter(),
+	}
+}
+
+func expandParameter() object {
+	return queryParameter("expand", "comma separated foreign key columns, referenced rows are returned in _expanded", false)
+}
+
+func idParameter(table Table) object {
+	return object{
+		"name":        "id",
+		"in":          "path",
+		"required":    true,
+		"description": "values of " + strings.Join(table.PrimaryKey, ", ") + " separated by commas",
+		"schema":      object{"type": "string"},
+	}
+}
+
+func recordSchema(table Table) object {
+	properties := object{}
+	required := []string{}
+	for _, col := range table.Columns {
+		properties[col.Name] = columnSchema(col)
+		required = append(required, col.Name)
+	}
+	return object{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// inputSchema lists the columns a client may send: key columns can not be changed by an update
+// and read-only columns can not be set at all. Omitted columns get their defaults, so none is required
+func inputSchema(acc *access, table Table, patch bool) object {
+	properties := object{}
+	for _, col := range table.Columns {
+		if patch && col.IsPrimaryKey || !acc.canSet(table.Name, col.Name) {
+			continue
+		}
+		properties[col.Name] = columnSchema(col)
+	}
+	return object{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func columnSchema(col Column) object {
+	ct := parseColumnType(col.Type)
+	schema := object{}
+	switch {
+	case ct.isInteger():
+		schema["type"] = "integer"
+		if ct.Unsigned {
+			schema["minimum"] = 0
+		}
+	case ct.isFloat():
+		schema["type"] = "number"
+		if ct.Unsigned {
+			schema["minimum"] = 0
+		}
+	case ct.isString():
+		schema["type"] = "string"
+		if ct.Base == "enum" {
+			schema["enum"] = ct.Enum
+		} else if _, isText := textLimit(ct.Base); isText {
+			if col.MaxBytes > 0 {
+				schema["maxLength"] = col.MaxBytes
+			}
+		} else if ct.Size > 0 {
+			schema["maxLength"] = ct.Size
+		}
+	case ct.isDateTime():
+		schema["type"] = "string"
+		if ct.Base == "date" {
+			schema["format"] = "date"
+		} else {
+			schema["example"] = "2006-01-02 15:04:05"
+		}
+	default:
+		schema["type"] = "string"
+	}
+
+	// sqlite reports key columns as nullable, but a stored row always has its key
+	if col.IsNullable && !col.IsPrimaryKey {
+		schema["nullable"] = true
+	}
+	if col.Comment != "" {
+		schema["description"] = col.Comment
+	}
+	return schema
+}
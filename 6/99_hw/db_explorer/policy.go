@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Policy describes what every API token may do. Tokens are passed as "Authorization: Bearer <token>"
+type Policy struct {
+	// Anonymous is the role for requests without a token, empty means they are rejected
+	Anonymous string            `json:"anonymous"`
+	Tokens    map[string]string `json:"tokens"`
+	Roles     map[string]Role   `json:"roles"`
+}
+
+type Role struct {
+	// Admin allows service endpoints such as /_reload
+	Admin bool `json:"admin"`
+	// Tables is keyed by table name, "*" applies to tables without their own entry
+	Tables map[string]TablePolicy `json:"tables"`
+}
+
+type TablePolicy struct {
+	Read     bool     `json:"read"`
+	Write    bool     `json:"write"`
+	Hidden   []string `json:"hidden"`
+	ReadOnly []string `json:"read_only"`
+}
+
+func LoadPolicy(r io.Reader) (*Policy, error) {
+	policy := &Policy{}
+	if err := json.NewDecoder(r).Decode(policy); err != nil {
+		return nil, err
+	}
+
+	for _, roleName := range policy.Tokens {
+		if _, ok := policy.Roles[roleName]; !ok {
+			return nil, fmt.Errorf("unknown role %s", roleName)
+		}
+	}
+	if _, ok := policy.Roles[policy.Anonymous]; policy.Anonymous != "" && !ok {
+		return nil, fmt.Errorf("unknown anonymous role %s", policy.Anonymous)
+	}
+	return policy, nil
+}
+
+func WithPolicy(policy *Policy) Option {
+	return func(dbe *DBExplorer) {
+		dbe.policy = policy
+	}
+}
+
+// access is the resolved role of one request, nil access means no policy is configured and everything is allowed
+type access struct {
+	token    string
+	roleName string
+	role     Role
+}
+
+type accessKey struct{}
+
+func (dbe *DBExplorer) resolveAccess(r *http.Request) (*access, error) {
+	if dbe.policy == nil {
+		return nil, nil
+	}
+
+	var token string
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, value, _ := strings.Cut(header, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return nil, fmt.Errorf("invalid authorization scheme")
+		}
+		token = strings.TrimSpace(value)
+		if token == "" {
+			return nil, fmt.Errorf("invalid token")
+		}
+	}
+	roleName, ok := dbe.policy.Tokens[token]
+	if token == "" || !ok {
+		if token != "" || dbe.policy.Anonymous == "" {
+			return nil, fmt.Errorf("invalid token")
+		}
+		roleName = dbe.policy.Anonymous
+	}
+	return &access{token: token, roleName: roleName, role: dbe.policy.Roles[roleName]}, nil
+}
+
+func accessFrom(ctx context.Context) *access {
+	acc, _ := ctx.Value(accessKey{}).(*access)
+	return acc
+}
+
+func (acc *access) table(tableName string) TablePolicy {
+	if tp, ok := acc.role.Tables[tableName]; ok {
+		return tp
+	}
+	return acc.role.Tables["*"]
+}
+
+func (acc *access) canAdmin() error {
+	if acc == nil || acc.role.Admin {
+		return nil
+	}
+	return fmt.Errorf("access denied")
+}
+
+// canRead and canWrite are checked before the table is looked up, so a denied table
+// gets the same 403 whether it exists or not
+func (acc *access) canRead(tableName string) error {
+	if acc == nil || acc.table(tableName).Read {
+		return nil
+	}
+	return fmt.Errorf("read access to %s denied", tableName)
+}
+
+func (acc *access) canWrite(tableName string) error {
+	if acc == nil || acc.table(tableName).Write {
+		return nil
+	}
+	return fmt.Errorf("write access to %s denied", tableName)
+}
+
+func (acc *access) isHidden(tableName, column string) bool {
+	if acc == nil {
+		return false
+	}
+	for _, hidden := range acc.table(tableName).Hidden {
+		if hidden == column {
+			return true
+		}
+	}
+	return false
+}
+
+// checkColumns rejects filters, sorting and projections on hidden columns, otherwise their values could be guessed
+func (acc *access) checkColumns(tableName string, columns []string) error {
+	for _, column := range columns {
+		if acc.isHidden(tableName, column) {
+			return fmt.Errorf("column %s is hidden", column)
+		}
+	}
+	return nil
+}
+
+// checkWrite rejects any attempt to set a hidden or read-only column
+func (acc *access) checkWrite(tableName string, data map[string]interface{}) error {
+	if err := acc.canWrite(tableName); err != nil {
+		return err
+	}
+	if acc == nil {
+		return nil
+	}
+
+	tp := acc.table(tableName)
+	for _, columns := range [][]string{tp.ReadOnly, tp.Hidden} {
+		for _, column := range columns {
+			if _, exists := data[column]; exists {
+				return fmt.Errorf("field %s is read-only", column)
+			}
+		}
+	}
+	return nil
+}
+
+func (acc *access) canSet(tableName, column string) bool {
+	if acc == nil {
+		return true
+	}
+	tp := acc.table(tableName)
+	return !slices.Contains(tp.ReadOnly, column) && !slices.Contains(tp.Hidden, column)
+}
+
+func (acc *access) maskRecords(tableName string, records []map[string]interface{}) {
+	if acc == nil {
+		return
+	}
+	for _, column := range acc.table(tableName).Hidden {
+		for _, record := range records {
+			delete(record, column)
+		}
+	}
+}
+
+func (acc *access) maskTable(table Table) Table {
+	if acc == nil {
+		return table
+	}
+	columns := make([]Column, 0, len(table.Columns))
+	for _, col := range table.Columns {
+		if !acc.isHidden(table.Name, col.Name) {
+			columns = append(columns, col)
+		}
+	}
+	table.Columns = columns
+
+	table.PrimaryKey = slices.DeleteFunc(slices.Clone(table.PrimaryKey), func(name string) bool {
+		return acc.isHidden(table.Name, name)
+	})
+	// an index over a hidden column would tell it exists, so it goes away as a whole
+	table.Indexes = slices.DeleteFunc(slices.Clone(table.Indexes), func(index Index) bool {
+		return slices.ContainsFunc(index.Columns, func(name string) bool {
+			return acc.isHidden(table.Name, name)
+		})
+	})
+	table.ForeignKeys = slices.DeleteFunc(slices.Clone(table.ForeignKeys), func(fk ForeignKey) bool {
+		return acc.isHidden(table.Name, fk.Column) || acc.isHidden(fk.RefTable, fk.RefColumn)
+	})
+	return table
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// filterOperator maps the op of where[col][op] to sql, in and null are built separately
+func filterOperator(op string) (string, bool) {
+	switch op {
+	case "eq":
+		return "=", true
+	case "ne":
+		return "<>", true
+	case "gt":
+		return ">", true
+	case "gte":
+		return ">=", true
+	case "lt":
+		return "<", true
+	case "lte":
+		return "<=", true
+	case "like":
+		return "LIKE", true
+	case "in":
+		return "IN", true
+	case "null":
+		return "IS NULL", true
+	}
+	return "", false
+}
+
+type listQuery struct {
+	Fields []string
+	Where  []condition
+	Order  []orderBy
+	Limit  int
+	Offset int
+	// Unlimited drops LIMIT/OFFSET, it is never set from request params
+	Unlimited bool
+
+	// Keyset is Order with primary key columns appended, so that the row order is stable
+	Keyset []orderBy
+	// After holds Keyset values of the last row of the previous page when ?cursor= is passed
+	After []interface{}
+
+	// Search is set only by /$table/_search
+	Search *textSearch
+	Expand []ForeignKey
+	// HideDeleted filters out soft deleted rows
+	HideDeleted bool
+}
+
+type condition struct {
+	Column string
+	Op     string
+	Values []interface{}
+}
+
+type orderBy struct {
+	Column string
+	Desc   bool
+}
+
+func (t Table) findColumn(name string) (Column, bool) {
+	for _, col := range t.Columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return Column{}, false
+}
+
+// parseListQuery validates fields, order and where[col][op] params against table columns
+func parseListQuery(table Table, params url.Values) (listQuery, error) {
+	lq := listQuery{
+		Limit:  5,
+		Offset: 0,
+	}
+
+	if l := params.Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil {
+			lq.Limit = parsed
+		}
+	}
+	if o := params.Get("offset"); o != "" {
+		if parsed, err := strconv.Atoi(o); err == nil {
+			lq.Offset = parsed
+		}
+	}
+
+	if f := params.Get("fields"); f != "" {
+		for _, name := range strings.Split(f, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := table.findColumn(name); !ok {
+				return lq, fmt.Errorf("unknown column %s", name)
+			}
+			lq.Fields = append(lq.Fields, name)
+		}
+	}
+
+	if o := params.Get("order"); o != "" {
+		for _, name := range strings.Split(o, ",") {
+			name = strings.TrimSpace(name)
+			ob := orderBy{Column: name}
+			if strings.HasPrefix(name, "-") {
+				ob.Column = name[1:]
+				ob.Desc = true
+			}
+			if _, ok := table.findColumn(ob.Column); !ok {
+				return lq, fmt.Errorf("unknown column %s", ob.Column)
+			}
+			lq.Order = append(lq.Order, ob)
+		}
+	}
+
+	lq.Keyset = append(lq.Keyset, lq.Order...)
+	for _, name := range table.PrimaryKey {
+		if !lq.inOrder(name) {
+			lq.Keyset = append(lq.Keyset, orderBy{Column: name})
+		}
+	}
+
+	expand, err := parseExpand(table, params.Get("expand"))
+	if err != nil {
+		return lq, err
+	}
+	lq.Expand = expand
+
+	if c := params.Get("cursor"); c != "" {
+		after, err := lq.decodeCursor(table, c)
+		if err != nil {
+			return lq, err
+		}
+		lq.After = after
+	}
+
+	lq.HideDeleted = table.softDelete() && params.Get("with_deleted") != "1"
+	lq.Where, err = parseWhere(table, params)
+	return lq, err
+}
+
+func parseWhere(table Table, params url.Values) ([]condition, error) {
+	var whereKeys []string
+	for key := range params {
+		if strings.HasPrefix(key, "where[") {
+			whereKeys = append(whereKeys, key)
+		}
+	}
+	sort.Strings(whereKeys)
+
+	var where []condition
+	for _, key := range whereKeys {
+		cond, err := parseCondition(table, key, params.Get(key))
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, cond)
+	}
+	return where, nil
+}
+
+func (lq listQuery) inOrder(column string) bool {
+	for _, o := range lq.Order {
+		if o.Column == column {
+			return true
+		}
+	}
+	return false
+}
+
+// missingFields are keyset, search and expand columns left out by ?fields=, they are selected anyway
+// to build the cursor, flag matches and look up relations, and removed from the response
+func (lq listQuery) missingFields() []string {
+	if len(lq.Fields) == 0 {
+		return nil
+	}
+	needed := make([]string, 0, len(lq.Keyset))
+	for _, o := range lq.Keyset {
+		needed = append(needed, o.Column)
+	}
+	if lq.Search != nil {
+		needed = append(needed, lq.Search.Columns...)
+	}
+	needed = append(needed, expandColumns(lq.Expand)...)
+
+	var missing []string
+	for _, column := range needed {
+		if !slices.Contains(lq.Fields, column) && !slices.Contains(missing, column) {
+			missing = append(missing, column)
+		}
+	}
+	return missing
+}
+
+// columns are the columns the request names, with a cursor also the key columns whose values it carries
+func (lq listQuery) columns() []string {
+	columns := append([]string{}, lq.Fields...)
+	for _, c := range lq.Where {
+		columns = append(columns, c.Column)
+	}
+	for _, o := range lq.Order {
+		columns = append(columns, o.Column)
+	}
+	if lq.After != nil {
+		columns = append(columns, lq.keysetColumns()...)
+	}
+	return append(columns, expandColumns(lq.Expand)...)
+}
+
+// where[age] is the same as where[age][eq]
+func parseCondition(table Table, key, value string) (condition, error) {
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(key, "where["), "]"), "][")
+
+	cond := condition{Column: parts[0], Op: "eq"}
+	if len(parts) == 2 {
+		cond.Op = parts[1]
+	} else if len(parts) > 2 {
+		return cond, fmt.Errorf("invalid filter %s", key)
+	}
+
+	if _, ok := table.findColumn(cond.Column); !ok {
+		return cond, fmt.Errorf("unknown column %s", cond.Column)
+	}
+	if _, ok := filterOperator(cond.Op); !ok {
+		return cond, fmt.Errorf("unknown operator %s", cond.Op)
+	}
+
+	switch cond.Op {
+	case "in":
+		for _, v := range strings.Split(value, ",") {
+			cond.Values = append(cond.Values, v)
+		}
+	case "null":
+		isNull, err := strconv.ParseBool(value)
+		if err != nil {
+			return cond, fmt.Errorf("invalid value for %s", key)
+		}
+		cond.Values = append(cond.Values, isNull)
+	default:
+		cond.Values = append(cond.Values, value)
+	}
+	return cond, nil
+}
+
+func (c condition) sql(d Dialect) (string, []interface{}) {
+	column := d.Quote(c.Column)
+	switch c.Op {
+	case "in":
+		placeholders := strings.Repeat("?,", len(c.Values))
+		return fmt.Sprintf("%s IN (%s)", column, placeholders[:len(placeholders)-1]), c.Values
+	case "null":
+		if c.Values[0].(bool) {
+			return column + " IS NULL", nil
+		}
+		return column + " IS NOT NULL", nil
+	}
+	op, _ := filterOperator(c.Op)
+	return fmt.Sprintf("%s %s ?", column, op), c.Values
+}
+
+func conditionsSQL(d Dialect, where []condition) ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	for _, c := range where {
+		condSQL, condArgs := c.sql(d)
+		conds = append(conds, condSQL)
+		args = append(args, condArgs...)
+	}
+	return conds, args
+}
+
+func (lq listQuery) sql(d Dialect, tableName string) (string, []interface{}) {
+	fields := "*"
+	if len(lq.Fields) > 0 {
+		quoted := make([]string, 0, len(lq.Fields))
+		for _, f := range append(lq.Fields, lq.missingFields()...) {
+			quoted = append(quoted, d.Quote(f))
+		}
+		fields = strings.Join(quoted, ", ")
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", fields, d.Quote(tableName))
+
+	conds, args := conditionsSQL(d, lq.Where)
+	if lq.HideDeleted {
+		conds = append(conds, d.Quote(deletedAtColumn)+" IS NULL")
+	}
+	if lq.Search != nil {
+		condSQL, condArgs := lq.Search.sql(d)
+		conds = append(conds, condSQL)
+		args = append(args, condArgs...)
+	}
+	if lq.After != nil {
+		condSQL, condArgs := lq.keysetCondition(d)
+		conds = append(conds, condSQL)
+		args = append(args, condArgs...)
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	if len(lq.Keyset) > 0 {
+		var orders []string
+		for _, o := range lq.Keyset {
+			if o.Desc {
+				orders = append(orders, d.Quote(o.Column)+" DESC")
+			} else {
+				orders = append(orders, d.Quote(o.Column)+" ASC")
+			}
+		}
+		query += " ORDER BY " + strings.Join(orders, ", ")
+	}
+
+	if !lq.Unlimited {
+		offset := lq.Offset
+		if lq.After != nil {
+			offset = 0
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, lq.Limit, offset)
+	}
+	return query, args
+}
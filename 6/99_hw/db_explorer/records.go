@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// queryer is a connection or a transaction bound to the request context, see conn and inTx
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqlConn is satisfied by both *sql.DB and *sql.Tx
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// boundConn runs every query with the context of the request, so queries are cancelled
+// together with the request and stop at its deadline
+type boundConn struct {
+	ctx  context.Context
+	conn sqlConn
+	tx   bool
+}
+
+func (c boundConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(c.ctx, query, args...)
+}
+
+func (c boundConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(c.ctx, query, args...)
+}
+
+func (c boundConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(c.ctx, query, args...)
+}
+
+func (dbe *DBExplorer) conn(ctx context.Context) queryer {
+	return boundConn{ctx: ctx, conn: dbe.db}
+}
+
+type apiError struct {
+	Code    int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+type indexedError struct {
+	Index int
+	Err   error
+}
+
+func (e *indexedError) Error() string {
+	return fmt.Sprintf("record %d: %s", e.Index, e.Err)
+}
+
+func (e *indexedError) Unwrap() error {
+	return e.Err
+}
+
+func errorCode(err error) int {
+	var ve validationErrors
+	var ae *apiError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return 504
+	case errors.Is(err, context.Canceled):
+		// the client has gone away, the status is only for logs
+		return 499
+	case errors.As(err, &ve):
+		return 400
+	case errors.As(err, &ae):
+		return ae.Code
+	}
+	return 500
+}
+
+func (dbe *DBExplorer) sendFailure(w http.ResponseWriter, err error) {
+	response := map[string]interface{}{
+		"error": err.Error(),
+	}
+
+	var ve validationErrors
+	if errors.As(err, &ve) {
+		response["fields"] = ve
+	}
+	var ie *indexedError
+	if errors.As(err, &ie) {
+		response["index"] = ie.Index
+	}
+
+	w.WriteHeader(errorCode(err))
+	json.NewEncoder(w).Encode(response)
+}
+
+func (dbe *DBExplorer) inTx(ctx context.Context, fn func(tx queryer) error) error {
+	tx, err := dbe.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(boundConn{ctx: ctx, conn: tx, tx: true}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// fetchRecord returns nil when there is no such row. Inside a transaction the row is locked until commit
+func (dbe *DBExplorer) fetchRecord(q queryer, table Table, key recordKey) (map[string]interface{}, error) {
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s", dbe.dialect.Quote(table.Name), dbe.keyWhere(table))
+	if c, ok := q.(boundConn); ok && c.tx {
+		query += dbe.dialect.ForUpdate()
+	}
+	rows, err := q.Query(dbe.dialect.Rebind(query), key...)
+	if err != nil {
+		return nil, err
+	}
+	records, err := dbe.readRows(rows)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+func (dbe *DBExplorer) insertRecord(q queryer, acc *access, table Table, data map[string]interface{}) (recordKey, error) {
+	key, err := dbe.insertRow(q, table, data)
+	if err != nil {
+		return nil, err
+	}
+	return key, dbe.auditWrite(q, acc, "create", table, key, nil)
+}
+
+func (dbe *DBExplorer) insertRow(q queryer, table Table, data map[string]interface{}) (recordKey, error) {
+	var keys []string
+	var values []interface{}
+	errs := validationErrors{}
+
+	for _, col := range table.Columns {
+		if value, exists := data[col.Name]; exists {
+			value, err := validateValue(col, value)
+			if err != nil {
+				errs[col.Name] = err.Error()
+				continue
+			}
+
+			keys = append(keys, dbe.dialect.Quote(col.Name))
+			values = append(values, value)
+		} else if !col.IsNullable && col.Default == nil && !col.isAutoIncrement() {
+			keys = append(keys, dbe.dialect.Quote(col.Name))
+			if parseColumnType(col.Type).isInteger() {
+				values = append(values, 0)
+			} else {
+				values = append(values, "")
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if len(values) == 0 {
+		return nil, &apiError{400, "nothing to insert"}
+	}
+
+	placeholders := strings.Repeat("?,", len(values))
+	placeholders = placeholders[:len(placeholders)-1]
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		dbe.dialect.Quote(table.Name), strings.Join(keys, ","), placeholders)
+
+	if dbe.dialect.UseReturning() && len(table.PrimaryKey) > 0 {
+		key := make(recordKey, len(table.PrimaryKey))
+		pointers := make([]interface{}, len(key))
+		returning := make([]string, len(key))
+		for i, name := range table.PrimaryKey {
+			pointers[i] = &key[i]
+			returning[i] = dbe.dialect.Quote(name)
+		}
+		query += " RETURNING " + strings.Join(returning, ", ")
+		if err := q.QueryRow(dbe.dialect.Rebind(query), values...).Scan(pointers...); err != nil {
+			return nil, err
+		}
+		return normalizeKey(key), nil
+	}
+
+	// without RETURNING only an auto increment id can be read back, the other keys must be in data.
+	// They are checked before the insert, so a written row is never reported as an error.
+	// A table without a primary key has no key to return
+	autoID := false
+	if table.hasAutoIncrementKey() {
+		_, given := data[table.PrimaryKey[0]]
+		autoID = !given
+	}
+	var key recordKey
+	if !autoID && len(table.PrimaryKey) > 0 {
+		var err error
+		if key, err = keyFromData(table, data); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := q.Exec(dbe.dialect.Rebind(query), values...)
+	if err != nil {
+		return nil, err
+	}
+	if autoID {
+		lastID, err := result.LastInsertId()
+		return recordKey{lastID}, err
+	}
+	return key, nil
+}
+
+func (dbe *DBExplorer) insertRecords(ctx context.Context, acc *access, table Table, records []interface{}) ([]interface{}, error) {
+	ids := make([]interface{}, 0, len(records))
+	err := dbe.inTx(ctx, func(tx queryer) error {
+		for i, record := range records {
+			data, ok := record.(map[string]interface{})
+			if !ok {
+				return &indexedError{i, &apiError{400, "invalid json"}}
+			}
+			key, err := dbe.insertRecord(tx, acc, table, data)
+			if err != nil {
+				return &indexedError{i, err}
+			}
+			ids = append(ids, key.value(table))
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// updateRecord changes one row, a soft deleted row is not found unless withDeleted is set
+func (dbe *DBExplorer) updateRecord(q queryer, acc *access, table Table, key recordKey, data map[string]interface{}, withDeleted bool) (int, error) {
+	var setSplits []string
+	var values []interface{}
+	errs := validationErrors{}
+
+	for _, column := range table.Columns {
+		value, exists := data[column.Name]
+		if !exists {
+			continue
+		}
+
+		if column.IsPrimaryKey {
+			errs[column.Name] = fmt.Sprintf("field %s have invalid type", column.Name)
+			continue
+		}
+
+		value, err := validateValue(column, value)
+		if err != nil {
+			errs[column.Name] = err.Error()
+			continue
+		}
+
+		setSplits = append(setSplits, dbe.dialect.Quote(column.Name)+" = ?")
+		values = append(values, value)
+	}
+
+	if len(errs) > 0 {
+		return 0, errs
+	}
+
+	if len(setSplits) == 0 {
+		return 0, &apiError{400, "nothing to update"}
+	}
+
+	hideDeleted := table.softDelete() && !withDeleted
+	if hideDeleted {
+		record, err := dbe.fetchRecord(q, table, key)
+		if err != nil {
+			return 0, err
+		}
+		if record != nil && table.isDeleted(record) {
+			return 0, &apiError{404, "record not found"}
+		}
+	}
+
+	before, err := dbe.auditBefore(q, table, key)
+	if err != nil {
+		return 0, err
+	}
+
+	values = append(values, key...)
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		dbe.dialect.Quote(table.Name), strings.Join(setSplits, ", "), dbe.keyWhere(table))
+	if hideDeleted {
+		// the row may be deleted by another request after the check
+		query += " AND " + dbe.dialect.Quote(deletedAtColumn) + " IS NULL"
+	}
+
+	result, err := q.Exec(dbe.dialect.Rebind(query), values...)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		return 0, nil
+	}
+	return int(affected), dbe.auditWrite(q, acc, "update", table, key, before)
+}
+
+// updateRecords takes the key of every record from its primary key fields
+func (dbe *DBExplorer) updateRecords(ctx context.Context, acc *access, table Table, records []map[string]interface{}, withDeleted bool) (int, error) {
+	total := 0
+	err := dbe.inTx(ctx, func(tx queryer) error {
+		for i, record := range records {
+			key, err := keyFromData(table, record)
+			if err != nil {
+				return &indexedError{i, err}
+			}
+
+			data := make(map[string]interface{}, len(record))
+			for k, v := range record {
+				if !table.isKeyColumn(k) {
+					data[k] = v
+				}
+			}
+
+			affected, err := dbe.updateRecord(tx, acc, table, key, data, withDeleted)
+			if err != nil {
+				return &indexedError{i, err}
+			}
+			total += affected
+		}
+		return nil
+	})
+	return total, err
+}
+
+func (dbe *DBExplorer) deleteRecord(q queryer, acc *access, table Table, key recordKey) (int, error) {
+	before, err := dbe.auditBefore(q, table, key)
+	if err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", dbe.dialect.Quote(table.Name), dbe.keyWhere(table))
+	args := append([]interface{}{}, key...)
+	if table.softDelete() {
+		deletedAt := dbe.dialect.Quote(deletedAtColumn)
+		query = fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s AND %s IS NULL",
+			dbe.dialect.Quote(table.Name), deletedAt, dbe.keyWhere(table), deletedAt)
+		args = append([]interface{}{table.deletedNow()}, key...)
+	}
+
+	result, err := q.Exec(dbe.dialect.Rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		return 0, nil
+	}
+	return int(affected), dbe.auditWrite(q, acc, "delete", table, key, before)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+func (dbe *DBExplorer) reloadSchema(w http.ResponseWriter, r *http.Request) {
+	if err := accessFrom(r.Context()).canAdmin(); err != nil {
+		dbe.sendError(w, err.Error(), 403)
+		return
+	}
+
+	if err := dbe.loadTable(r.Context()); err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+
+	dbe.showTable(w, r)
+}
+
+// watchSchema keeps serving the previous schema if a reload fails
+func (dbe *DBExplorer) watchSchema() {
+	defer dbe.watching.Done()
+	ticker := time.NewTicker(dbe.reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := dbe.loadTable(context.Background()); err != nil {
+				log.Println("db_explorer: schema reload failed:", err)
+			}
+		case <-dbe.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background schema reload and waits for a reload in progress
+func (dbe *DBExplorer) Close() error {
+	dbe.closeOnce.Do(func() {
+		close(dbe.stop)
+	})
+	dbe.watching.Wait()
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+)
+
+type Index struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+	Type    string   `json:"type"`
+}
+
+type ForeignKey struct {
+	Name      string `json:"name"`
+	Column    string `json:"column"`
+	RefTable  string `json:"ref_table"`
+	RefColumn string `json:"ref_column"`
+}
+
+func scanIndexes(rows *sql.Rows) ([]Index, error) {
+	defer rows.Close()
+
+	indexes := []Index{}
+	for rows.Next() {
+		var name, column, indexType string
+		var unique bool
+		if err := rows.Scan(&name, &unique, &column, &indexType); err != nil {
+			return nil, err
+		}
+
+		if n := len(indexes); n > 0 && indexes[n-1].Name == name {
+			indexes[n-1].Columns = append(indexes[n-1].Columns, column)
+			continue
+		}
+		indexes = append(indexes, Index{
+			Name:    name,
+			Columns: []string{column},
+			Unique:  unique,
+			Type:    indexType,
+		})
+	}
+	return indexes, rows.Err()
+}
+
+func scanForeignKeys(rows *sql.Rows) ([]ForeignKey, error) {
+	defer rows.Close()
+
+	foreignKeys := []ForeignKey{}
+	for rows.Next() {
+		var fk ForeignKey
+		if err := rows.Scan(&fk.Name, &fk.Column, &fk.RefTable, &fk.RefColumn); err != nil {
+			return nil, err
+		}
+		foreignKeys = append(foreignKeys, fk)
+	}
+	return foreignKeys, rows.Err()
+}
+
+func (dbe *DBExplorer) showSchema(w http.ResponseWriter, r *http.Request, tableName string) {
+	acc := accessFrom(r.Context())
+	if err := acc.canRead(tableName); err != nil {
+		dbe.sendError(w, err.Error(), 403)
+		return
+	}
+
+	table, ok := dbe.table(tableName)
+	if !ok {
+		dbe.sendError(w, "unknown table", 404)
+		return
+	}
+	table = acc.maskTable(table)
+
+	var response = map[string]interface{}{
+		"response": map[string]interface{}{
+			"table": table,
+		},
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// textSearch looks for a phrase in the text columns of a table. Columns covered by a FULLTEXT index
+// are matched with MATCH ... AGAINST, the rest with a case-insensitive LIKE
+type textSearch struct {
+	Text     string
+	Columns  []string
+	FullText [][]string
+}
+
+func newTextSearch(d Dialect, table Table, acc *access, text string) (*textSearch, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, fmt.Errorf("empty search query")
+	}
+
+	ts := &textSearch{Text: text}
+	for _, col := range table.Columns {
+		if parseColumnType(col.Type).isString() && !acc.isHidden(table.Name, col.Name) {
+			ts.Columns = append(ts.Columns, col.Name)
+		}
+	}
+	if len(ts.Columns) == 0 {
+		return nil, fmt.Errorf("table has no text columns")
+	}
+
+	if d.Name() == "mysql" {
+		for _, index := range table.Indexes {
+			if strings.EqualFold(index.Type, "FULLTEXT") && ts.covers(index.Columns) {
+				ts.FullText = append(ts.FullText, index.Columns)
+			}
+		}
+	}
+	return ts, nil
+}
+
+func (ts *textSearch) covers(columns []string) bool {
+	for _, column := range columns {
+		if !slices.Contains(ts.Columns, column) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ts *textSearch) inFullText(column string) bool {
+	for _, index := range ts.FullText {
+		if slices.Contains(index, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// likePattern escapes the wildcards with "!", backslash is not a portable escape character
+func likePattern(text string) string {
+	replacer := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return "%" + replacer.Replace(strings.ToLower(text)) + "%"
+}
+
+func (ts *textSearch) sql(d Dialect) (string, []interface{}) {
+	var ors []string
+	var args []interface{}
+	for _, index := range ts.FullText {
+		quoted := make([]string, 0, len(index))
+		for _, column := range index {
+			quoted = append(quoted, d.Quote(column))
+		}
+		ors = append(ors, fmt.Sprintf("MATCH(%s) AGAINST (? IN NATURAL LANGUAGE MODE)", strings.Join(quoted, ", ")))
+		args = append(args, ts.Text)
+	}
+	for _, column := range ts.Columns {
+		if ts.inFullText(column) {
+			continue
+		}
+		ors = append(ors, fmt.Sprintf("LOWER(%s) LIKE ? ESCAPE '!'", d.Quote(column)))
+		args = append(args, likePattern(ts.Text))
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// matched repeats the check in go to tell which columns of a found record contain the phrase.
+// A FULLTEXT column counts when it has any word of the query, as MATCH does
+func (ts *textSearch) matched(record map[string]interface{}) []string {
+	text := strings.ToLower(ts.Text)
+	words := strings.Fields(text)
+
+	matched := []string{}
+	for _, column := range ts.Columns {
+		value, ok := record[column].(string)
+		if !ok {
+			continue
+		}
+		value = strings.ToLower(value)
+		if strings.Contains(value, text) {
+			matched = append(matched, column)
+			continue
+		}
+		if !ts.inFullText(column) {
+			continue
+		}
+		for _, word := range words {
+			if strings.Contains(value, word) {
+				matched = append(matched, column)
+				break
+			}
+		}
+	}
+	return matched
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const deletedAtColumn = "deleted_at"
+
+// softDelete is on for tables with a nullable deleted_at column: DELETE only marks the row
+// and deleted rows are hidden unless ?with_deleted=1 is passed
+func (t Table) softDelete() bool {
+	col, ok := t.findColumn(deletedAtColumn)
+	return ok && col.IsNullable
+}
+
+// deletedNow is the deleted_at value for a row deleted now, unix time for integer columns
+func (t Table) deletedNow() interface{} {
+	col, _ := t.findColumn(deletedAtColumn)
+	now := time.Now().UTC()
+	if parseColumnType(col.Type).isInteger() {
+		return now.Unix()
+	}
+	return now.Format("2006-01-02 15:04:05")
+}
+
+func (t Table) isDeleted(record map[string]interface{}) bool {
+	return t.softDelete() && record[deletedAtColumn] != nil
+}
+
+func withDeleted(r *http.Request) bool {
+	return r.URL.Query().Get("with_deleted") == "1"
+}
+
+func (dbe *DBExplorer) restoreRecord(q queryer, acc *access, table Table, key recordKey) (int, error) {
+	before, err := dbe.auditBefore(q, table, key)
+	if err != nil {
+		return 0, err
+	}
+
+	deletedAt := dbe.dialect.Quote(deletedAtColumn)
+	query := fmt.Sprintf("UPDATE %s SET %s = NULL WHERE %s AND %s IS NOT NULL",
+		dbe.dialect.Quote(table.Name), deletedAt, dbe.keyWhere(table), deletedAt)
+	result, err := q.Exec(dbe.dialect.Rebind(query), key...)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		return 0, nil
+	}
+	return int(affected), dbe.auditWrite(q, acc, "restore", table, key, before)
+}
+
+func (dbe *DBExplorer) restoreData(w http.ResponseWriter, r *http.Request, tableName, idStr string) {
+	acc := accessFrom(r.Context())
+	if err := acc.canWrite(tableName); err != nil {
+		dbe.sendError(w, err.Error(), 403)
+		return
+	}
+
+	table, ok := dbe.table(tableName)
+	if !ok {
+		dbe.sendError(w, "unknown table", 404)
+		return
+	}
+	if !table.softDelete() {
+		dbe.sendError(w, "table has no deleted_at column", 400)
+		return
+	}
+
+	key, err := parseKey(table, idStr, r.URL.Query())
+	if err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+
+	var affected int
+	err = dbe.inTx(r.Context(), func(tx queryer) (err error) {
+		affected, err = dbe.restoreRecord(tx, acc, table, key)
+		return err
+	})
+	if err != nil {
+		dbe.sendFailure(w, err)
+		return
+	}
+
+	var response = map[string]interface{}{
+		"response": map[string]interface{}{
+			"restored": affected,
+		},
+	}
+	json.NewEncoder(w).Encode(response)
+}
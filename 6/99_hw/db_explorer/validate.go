@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// textLimit is the size in bytes of the mysql text types, postgres and sqlite have the same names without limits
+func textLimit(base string) (int, bool) {
+	switch base {
+	case "tinytext":
+		return 255, true
+	case "text":
+		return 65535, true
+	case "mediumtext":
+		return 16777215, true
+	case "longtext":
+		return math.MaxInt32, true
+	}
+	return 0, false
+}
+
+// typeAlias maps the long sql names that postgres reports for some types
+func typeAlias(name string) (string, bool) {
+	switch name {
+	case "character varying":
+		return "varchar", true
+	case "character":
+		return "char", true
+	case "double precision":
+		return "double", true
+	}
+	return "", false
+}
+
+type columnType struct {
+	Base     string
+	Size     int
+	Scale    int
+	Unsigned bool
+	Enum     []string
+}
+
+// parseColumnType understands types as SHOW FULL COLUMNS prints them: int(11) unsigned, varchar(255), decimal(10,2), enum('a','b')
+func parseColumnType(raw string) columnType {
+	ct := columnType{}
+	raw = strings.ToLower(strings.TrimSpace(raw))
+	ct.Unsigned = strings.Contains(raw, "unsigned")
+
+	base, args := raw, ""
+	if open := strings.Index(raw, "("); open != -1 {
+		base = raw[:open]
+		if end := strings.LastIndex(raw, ")"); end > open {
+			args = raw[open+1 : end]
+		}
+	}
+	// aliases have spaces in them, so they are looked up before modifiers like unsigned are cut off
+	base = strings.TrimSpace(base)
+	if alias, ok := typeAlias(base); ok {
+		ct.Base = alias
+	} else if space := strings.Index(base, " "); space != -1 {
+		ct.Base = base[:space]
+	} else {
+		ct.Base = base
+	}
+
+	switch ct.Base {
+	case "enum", "set":
+		for _, v := range strings.Split(args, ",") {
+			ct.Enum = append(ct.Enum, strings.Trim(v, "'"))
+		}
+	default:
+		parts := strings.Split(args, ",")
+		ct.Size, _ = strconv.Atoi(parts[0])
+		if len(parts) > 1 {
+			ct.Scale, _ = strconv.Atoi(parts[1])
+		}
+	}
+	return ct
+}
+
+func (ct columnType) isInteger() bool {
+	return strings.HasSuffix(ct.Base, "int") || ct.Base == "integer"
+}
+
+// intBits is the storage size of integer types, the ones without a fixed size are treated as bigint
+func (ct columnType) intBits() int {
+	switch ct.Base {
+	case "tinyint":
+		return 8
+	case "smallint":
+		return 16
+	case "mediumint":
+		return 24
+	case "int", "integer":
+		return 32
+	}
+	return 64
+}
+
+// inRange checks an integral value against the type size, the bounds are exact powers of two in float64
+func (ct columnType) inRange(num float64) bool {
+	bits := float64(ct.intBits())
+	if ct.Unsigned {
+		return num >= 0 && num < math.Exp2(bits)
+	}
+	limit := math.Exp2(bits - 1)
+	return num >= -limit && num < limit
+}
+
+func (ct columnType) isFloat() bool {
+	switch ct.Base {
+	case "float", "double", "real", "decimal", "numeric":
+		return true
+	}
+	return false
+}
+
+func (ct columnType) isString() bool {
+	switch ct.Base {
+	case "char", "varchar", "enum", "set":
+		return true
+	}
+	_, ok := textLimit(ct.Base)
+	return ok
+}
+
+func (ct columnType) isDateTime() bool {
+	switch ct.Base {
+	case "datetime", "timestamp", "date":
+		return true
+	}
+	return false
+}
+
+// validationErrors maps field name to its error so all of them are returned at once
+type validationErrors map[string]string
+
+func (ve validationErrors) Error() string {
+	fields := make([]string, 0, len(ve))
+	for field := range ve {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return ve[fields[0]]
+}
+
+// validateValue checks a decoded json value against the column type and converts it for the driver
+func validateValue(col Column, value interface{}) (interface{}, error) {
+	invalidType := fmt.Errorf("field %s have invalid type", col.Name)
+	if value == nil {
+		if !col.IsNullable {
+			return nil, invalidType
+		}
+		return nil, nil
+	}
+
+	ct := parseColumnType(col.Type)
+	switch {
+	case ct.isInteger():
+		num, ok := value.(float64)
+		if !ok || num != math.Trunc(num) {
+			return nil, invalidType
+		}
+		if ct.Unsigned && num < 0 {
+			return nil, fmt.Errorf("field %s must be unsigned", col.Name)
+		}
+		if !ct.inRange(num) {
+			return nil, fmt.Errorf("field %s is out of range", col.Name)
+		}
+		// unsigned bigint above the int64 range
+		if num >= math.Exp2(63) {
+			return uint64(num), nil
+		}
+		return int64(num), nil
+
+	case ct.isFloat():
+		num, ok := value.(float64)
+		if !ok {
+			return nil, invalidType
+		}
+		if ct.Unsigned && num < 0 {
+			return nil, fmt.Errorf("field %s must be unsigned", col.Name)
+		}
+		if ct.Base == "decimal" || ct.Base == "numeric" {
+			if ct.Size > 0 && math.Abs(num) >= math.Pow10(ct.Size-ct.Scale) {
+				return nil, fmt.Errorf("field %s is out of range", col.Name)
+			}
+		}
+		return num, nil
+
+	case ct.isString():
+		str, ok := value.(string)
+		if !ok {
+			return nil, invalidType
+		}
+		if ct.Base == "enum" {
+			for _, allowed := range ct.Enum {
+				if str == allowed {
+					return str, nil
+				}
+			}
+			return nil, fmt.Errorf("field %s must be one of %s", col.Name, strings.Join(ct.Enum, ", "))
+		}
+		// text limits are in bytes, char and varchar sizes are in characters
+		if _, isText := textLimit(ct.Base); isText {
+			if col.MaxBytes > 0 && len(str) > col.MaxBytes {
+				return nil, fmt.Errorf("field %s is longer than %d bytes", col.Name, col.MaxBytes)
+			}
+		} else if ct.Size > 0 && utf8.RuneCountInString(str) > ct.Size {
+			return nil, fmt.Errorf("field %s is longer than %d", col.Name, ct.Size)
+		}
+		return str, nil
+
+	case ct.isDateTime():
+		str, ok := value.(string)
+		if !ok {
+			return nil, invalidType
+		}
+		t, ok := parseDateTime(str)
+		if !ok {
+			return nil, fmt.Errorf("field %s is not a valid datetime", col.Name)
+		}
+		return ct.formatDateTime(t), nil
+	}
+
+	return value, nil
+}
+
+// formatDateTime is t as the column stores it, the columns keep no zone so values with an offset are stored in UTC
+func (ct columnType) formatDateTime(t time.Time) string {
+	if ct.Base == "date" {
+		return t.UTC().Format("2006-01-02")
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// parseDateTime accepts the mysql format, RFC 3339 with or without the zone and a bare date
+func parseDateTime(str string) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, str); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}